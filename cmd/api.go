@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
+	"strconv"
+
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/utils/httputil"
 )
 
 // API represents an instance of an object that has connection to the gateway.
@@ -20,6 +24,175 @@ type API interface {
 	EditInteractionFollowup(appID discord.AppID, messageID discord.MessageID, token string, data api.EditInteractionResponseData) (*discord.Message, error)
 	DeleteInteractionFollowup(appID discord.AppID, messageID discord.MessageID, token string) error
 
+	Commands(appID discord.AppID) ([]discord.Command, error)
+	GuildCommands(appID discord.AppID, guildID discord.GuildID) ([]discord.Command, error)
+	CreateCommand(appID discord.AppID, data api.CreateCommandData) (*discord.Command, error)
+	CreateGuildCommand(appID discord.AppID, guildID discord.GuildID, data api.CreateCommandData) (*discord.Command, error)
+	EditCommand(appID discord.AppID, commandID discord.CommandID, data api.CreateCommandData) (*discord.Command, error)
+	EditGuildCommand(appID discord.AppID, guildID discord.GuildID, commandID discord.CommandID, data api.CreateCommandData) (*discord.Command, error)
+	DeleteCommand(appID discord.AppID, commandID discord.CommandID) error
+	DeleteGuildCommand(appID discord.AppID, guildID discord.GuildID, commandID discord.CommandID) error
 	BulkOverwriteCommands(appID discord.AppID, commands []api.CreateCommandData) ([]discord.Command, error)
 	BulkOverwriteGuildCommands(appID discord.AppID, guildID discord.GuildID, commands []api.CreateCommandData) ([]discord.Command, error)
 }
+
+// stateAPI is implemented by APIs that keep track of the state of guilds, such as *state.State. If the API passed to
+// the handler implements it, it is used to check the permissions of users and the channels commands are executed in.
+type stateAPI interface {
+	Permissions(channelID discord.ChannelID, userID discord.UserID) (discord.Permissions, error)
+	Guild(id discord.GuildID) (*discord.Guild, error)
+	Channel(id discord.ChannelID) (*discord.Channel, error)
+	Me() (*discord.User, error)
+}
+
+// rawAPI is implemented by APIs that can send raw requests to discord, such as *state.State. If the API passed to the
+// handler implements it, commands are registered with fields api.CreateCommandData does not support yet.
+type rawAPI interface {
+	RequestJSON(to interface{}, method, url string, opts ...httputil.RequestOption) error
+}
+
+// commandData is the data sent to discord to create a command. It extends api.CreateCommandData with the fields that
+// it does not support yet.
+type commandData struct {
+	api.CreateCommandData
+
+	DefaultMemberPermissions *discord.Permissions
+	DMPermission             bool
+	NSFW                     bool
+}
+
+// MarshalJSON ...
+func (c commandData) MarshalJSON() ([]byte, error) {
+	b, err := c.CreateCommandData.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	// Discord expects the permissions as a string, as they may not fit in a number in some languages.
+	fields["default_member_permissions"] = json.RawMessage("null")
+	if c.DefaultMemberPermissions != nil {
+		fields["default_member_permissions"], _ = json.Marshal(strconv.FormatUint(uint64(*c.DefaultMemberPermissions), 10))
+	}
+	fields["dm_permission"], _ = json.Marshal(c.DMPermission)
+	fields["nsfw"], _ = json.Marshal(c.NSFW)
+	return json.Marshal(fields)
+}
+
+// fetchCommands returns all commands of the application registered in the guild provided, or the global commands if
+// the guild is equal to discord.NullGuildID.
+func fetchCommands(discordAPI API, appID discord.AppID, guildID discord.GuildID) ([]discord.Command, error) {
+	if guildID.IsValid() {
+		return discordAPI.GuildCommands(appID, guildID)
+	}
+	return discordAPI.Commands(appID)
+}
+
+// bulkOverwriteCommands overwrites all commands of the application in the guild provided, or the global commands if
+// the guild is equal to discord.NullGuildID. If the API does not support raw requests, the fields not supported by
+// api.CreateCommandData are omitted.
+func bulkOverwriteCommands(discordAPI API, appID discord.AppID, guildID discord.GuildID, cmds []commandData) ([]discord.Command, error) {
+	if r, ok := discordAPI.(rawAPI); ok {
+		if cmds == nil {
+			cmds = []commandData{}
+		}
+
+		var registered []discord.Command
+		return registered, r.RequestJSON(&registered, "PUT", commandsURL(appID, guildID), httputil.WithJSONBody(cmds))
+	}
+
+	data := make([]api.CreateCommandData, 0, len(cmds))
+	for _, cmd := range cmds {
+		data = append(data, cmd.CreateCommandData)
+	}
+	if guildID.IsValid() {
+		return discordAPI.BulkOverwriteGuildCommands(appID, guildID, data)
+	}
+	return discordAPI.BulkOverwriteCommands(appID, data)
+}
+
+// commandsURL returns the URL of the commands of the application in the guild provided, or the URL of the global
+// commands if the guild is equal to discord.NullGuildID.
+func commandsURL(appID discord.AppID, guildID discord.GuildID) string {
+	if guildID.IsValid() {
+		return api.EndpointApplications + appID.String() + "/guilds/" + guildID.String() + "/commands"
+	}
+	return api.EndpointApplications + appID.String() + "/commands"
+}
+
+// fetchRemoteCommands returns all commands of the application registered in the guild provided, or the global commands
+// if the guild is equal to discord.NullGuildID, in their normalised form. The second return value is true if the
+// fields not supported by api.CreateCommandData were fetched as well.
+func fetchRemoteCommands(discordAPI API, appID discord.AppID, guildID discord.GuildID) ([]remoteCommand, bool, error) {
+	r, raw := discordAPI.(rawAPI)
+	var cmds []discord.Command
+	var rawCmds []json.RawMessage
+	if raw {
+		if err := r.RequestJSON(&rawCmds, "GET", commandsURL(appID, guildID)); err != nil {
+			return nil, false, err
+		}
+		cmds = make([]discord.Command, len(rawCmds))
+		for i, rawCmd := range rawCmds {
+			if err := json.Unmarshal(rawCmd, &cmds[i]); err != nil {
+				return nil, false, err
+			}
+		}
+	} else {
+		var err error
+		if cmds, err = fetchCommands(discordAPI, appID, guildID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	remote := make([]remoteCommand, len(cmds))
+	for i, cmd := range cmds {
+		var rawCmd json.RawMessage
+		if raw {
+			rawCmd = rawCmds[i]
+		}
+		normalised, err := normaliseRemote(cmd, rawCmd, guildID)
+		if err != nil {
+			return nil, false, err
+		}
+		remote[i] = remoteCommand{Command: cmd, normalised: normalised}
+	}
+	return remote, raw, nil
+}
+
+// createCommand registers a new command in the guild provided, or globally if the guild is equal to
+// discord.NullGuildID.
+func createCommand(discordAPI API, appID discord.AppID, guildID discord.GuildID, data commandData) (*discord.Command, error) {
+	if r, ok := discordAPI.(rawAPI); ok {
+		var cmd *discord.Command
+		return cmd, r.RequestJSON(&cmd, "POST", commandsURL(appID, guildID), httputil.WithJSONBody(data))
+	}
+	if guildID.IsValid() {
+		return discordAPI.CreateGuildCommand(appID, guildID, data.CreateCommandData)
+	}
+	return discordAPI.CreateCommand(appID, data.CreateCommandData)
+}
+
+// editCommand edits a command registered in the guild provided, or globally if the guild is equal to
+// discord.NullGuildID.
+func editCommand(discordAPI API, appID discord.AppID, guildID discord.GuildID, id discord.CommandID, data commandData) (*discord.Command, error) {
+	if r, ok := discordAPI.(rawAPI); ok {
+		var cmd *discord.Command
+		return cmd, r.RequestJSON(&cmd, "PATCH", commandsURL(appID, guildID)+"/"+id.String(), httputil.WithJSONBody(data))
+	}
+	if guildID.IsValid() {
+		return discordAPI.EditGuildCommand(appID, guildID, id, data.CreateCommandData)
+	}
+	return discordAPI.EditCommand(appID, id, data.CreateCommandData)
+}
+
+// deleteCommand deletes a command registered in the guild provided, or globally if the guild is equal to
+// discord.NullGuildID.
+func deleteCommand(discordAPI API, appID discord.AppID, guildID discord.GuildID, id discord.CommandID) error {
+	if guildID.IsValid() {
+		return discordAPI.DeleteGuildCommand(appID, guildID, id)
+	}
+	return discordAPI.DeleteCommand(appID, id)
+}
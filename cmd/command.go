@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
+	"sort"
 	"strings"
 )
 
@@ -17,26 +18,61 @@ const (
 	descMaxLength = 100
 )
 
-// Command is a slash command that can be registered and executed.
+// Command is a slash command that can be registered and executed. All builder methods return a changed copy of the
+// command, leaving the command they were called on unchanged.
 type Command struct {
-	name, description string
-	defaultEnabled    bool
-	guild             discord.GuildID
+	name, description        string
+	defaultEnabled           bool
+	defaultMemberPermissions *discord.Permissions
+	dmPermission             bool
+	nsfw                     bool
+	ephemeralDefer           bool
+	cooldown                 *Cooldown
+	guild                    discord.GuildID
+	scope                    []discord.GuildID
+	// prefix is put in front of the name of the command when registering it, which is done in development mode.
+	prefix string
 
-	executor    Executor
+	executor    any // Executor or ErrorExecutor
 	subcommands map[string]Subcommand
 	subGroups   map[string]string // name: description
+	// order contains the names of subcommand groups and the full names of subcommands in the order they were added.
+	order           []string
+	sorted          bool
+	middleware      []Middleware
+	groupMiddleware map[string][]Middleware
+	// source and groupSources are the locations the command and its subcommand groups were defined at, which are used
+	// to report conflicting definitions.
+	source       string
+	groupSources map[string]string
 
 	registered bool
+	// pendingSeq is the sequence number the command got when it became pending in a handler.
+	pendingSeq uint64
+	// fromPolicy is set for commands registered by the GuildPolicy of a handler, which are replaced every time the
+	// policy is applied to the guild again.
+	fromPolicy bool
 }
 
-// New creates a new slash command. By itself it will not do anything, and needs executors to be runnable.
+// New creates a new slash command. By itself it will not do anything, and needs executors to be runnable. New panics if
+// the name or description is invalid, use TryNew for commands that are not defined statically.
 func New(name, description string) Command {
-	mustMatch(name)
-	if len(name) < nameMinLength || len(name) > nameMaxLength {
-		panic(fmt.Sprintf("command name must be equal to or between %v and %v characters in length", nameMinLength, nameMaxLength))
-	} else if len(description) < descMinLength || len(description) > descMaxLength {
-		panic(fmt.Sprintf("command description must be equal to or between %v and %v characters in length", descMinLength, descMaxLength))
+	return must(newCommand(name, description))
+}
+
+// TryNew creates a new slash command in the same way as New. Instead of panicking, it returns ValidationErrors
+// containing every problem with the name and description if they are invalid.
+func TryNew(name, description string) (Command, error) {
+	return newCommand(name, description)
+}
+
+// newCommand creates a new slash command for New and TryNew, of which the caller is recorded as the location the
+// command was defined at.
+func newCommand(name, description string) (Command, error) {
+	path := "/" + name
+	errs := append(validateName(path, name), validateDescription(path, description)...)
+	if len(errs) > 0 {
+		return Command{}, errs
 	}
 
 	return Command{
@@ -45,40 +81,146 @@ func New(name, description string) Command {
 		subcommands: map[string]Subcommand{},
 		subGroups:   map[string]string{},
 
+		groupMiddleware: map[string][]Middleware{},
+
+		source:       callerSource(2),
+		groupSources: map[string]string{},
+
 		guild: discord.NullGuildID,
 
 		defaultEnabled: true,
-	}
+		dmPermission:   true,
+	}, nil
 }
 
 // WithExecutor returns the command with the executor provided. This will be the main executor for the command. If you
-// only want subcommands, this does not need to be provided.
+// only want subcommands, this does not need to be provided. WithExecutor panics if the command has subcommands or if
+// multiple fields of the executor result in the same option name, use Command.TryWithExecutor for executors that are
+// not defined statically.
 func (c Command) WithExecutor(e Executor) Command {
+	return must(c.withExecutor(e))
+}
+
+// WithErrorExecutor returns the command with the ErrorExecutor provided as main executor. It is otherwise the same as
+// Command.WithExecutor.
+func (c Command) WithErrorExecutor(e ErrorExecutor) Command {
+	return must(c.withExecutor(e))
+}
+
+// TryWithExecutor returns the command with the executor provided in the same way as Command.WithExecutor. Instead of
+// panicking, it returns ValidationErrors containing every problem with the executor, together with the command
+// unchanged.
+func (c Command) TryWithExecutor(e Executor) (Command, error) {
+	return c.withExecutor(e)
+}
+
+// TryWithErrorExecutor returns the command with the ErrorExecutor provided as main executor. It is otherwise the same as
+// Command.TryWithExecutor.
+func (c Command) TryWithErrorExecutor(e ErrorExecutor) (Command, error) {
+	return c.withExecutor(e)
+}
+
+// withExecutor sets the main executor of the command, which is either an Executor or ErrorExecutor.
+func (c Command) withExecutor(e any) (Command, error) {
+	path := "/" + c.name
+	var errs ValidationErrors
 	if len(c.subcommands) > 0 {
-		panic("Subcommands and main executor are mutually exclusive")
+		errs = append(errs, ValidationError{Path: path, Message: "subcommands and main executor are mutually exclusive"})
+	}
+	errs = append(errs, duplicateOptions(path, e)...)
+	if len(errs) > 0 {
+		return c, errs
 	}
 	c.executor = e
-	return c
+	return c, nil
 }
 
 // WithSubcommandGroup adds a new subcommand group to the command. This is essentially a folder for subcommands, and
-// allow for "double" subcommands: /<command> <subcommandGroup> <subcommand>
+// allow for "double" subcommands: /<command> <subcommandGroup> <subcommand>. WithSubcommandGroup panics if the name or
+// description is invalid, use Command.TryWithSubcommandGroup for groups that are not defined statically.
 func (c Command) WithSubcommandGroup(name, description string) Command {
+	return must(c.withSubcommandGroup(name, description))
+}
+
+// TryWithSubcommandGroup adds a new subcommand group to the command in the same way as Command.WithSubcommandGroup.
+// Instead of panicking, it returns ValidationErrors containing every problem with the name and description if they are
+// invalid or already used, together with the command unchanged.
+func (c Command) TryWithSubcommandGroup(name, description string) (Command, error) {
+	return c.withSubcommandGroup(name, description)
+}
+
+// withSubcommandGroup adds a new subcommand group to the command, of which the caller of the caller is recorded as the
+// location the group was defined at.
+func (c Command) withSubcommandGroup(name, description string) (Command, error) {
+	path := "/" + c.name + " " + name
+	source := callerSource(2)
+	errs := append(validateName(path, name), validateDescription(path, description)...)
+	if _, ok := c.subGroups[name]; ok {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("subcommand group is defined both at %s and at %s", c.groupSources[name], source)})
+	} else if sub, ok := c.subcommands[name]; ok {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("subcommand group defined at %s has the same name as the subcommand defined at %s", source, sub.source)})
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+
+	c.subGroups, c.groupSources = cloneMap(c.subGroups), cloneMap(c.groupSources)
+	c.order = append(c.order[:len(c.order):len(c.order)], name)
 	c.subGroups[name] = description
-	return c
+	c.groupSources[name] = source
+	return c, nil
 }
 
 // WithSubcommand adds a new subcommand to the command. It can be either a subcommand directly within the command
 // itself, or a subcommand within a subcommand group. To do the latter, you will need to enter the name of your
 // subcommand as a single string: "subcommand_group subcommand". To use subcommand groups, they must first be added
-// using Command.WithSubcommandGroup.
+// using Command.WithSubcommandGroup. WithSubcommand panics if the subcommand is invalid, use Command.TryWithSubcommand
+// for subcommands that are not defined statically.
 func (c Command) WithSubcommand(fullName, description string, e Executor) Command {
-	if c.executor != nil {
-		panic("Subcommands and main executor are mutually exclusive")
-	}
+	return must(c.withSubcommand(fullName, description, e, false))
+}
 
-	if len(description) < descMinLength || len(description) > descMaxLength {
-		panic(fmt.Sprintf("subcommand description must be equal to or between %v and %v characters in length", descMinLength, descMaxLength))
+// WithErrorSubcommand adds a new subcommand with an ErrorExecutor to the command. It is otherwise the same as
+// Command.WithSubcommand.
+func (c Command) WithErrorSubcommand(fullName, description string, e ErrorExecutor) Command {
+	return must(c.withSubcommand(fullName, description, e, false))
+}
+
+// TryWithSubcommand adds a new subcommand to the command in the same way as Command.WithSubcommand. Instead of
+// panicking, it returns ValidationErrors containing every problem with the subcommand if it is invalid, together with
+// the command unchanged.
+func (c Command) TryWithSubcommand(fullName, description string, e Executor) (Command, error) {
+	return c.withSubcommand(fullName, description, e, false)
+}
+
+// TryWithErrorSubcommand adds a new subcommand with an ErrorExecutor to the command. It is otherwise the same as
+// Command.TryWithSubcommand.
+func (c Command) TryWithErrorSubcommand(fullName, description string, e ErrorExecutor) (Command, error) {
+	return c.withSubcommand(fullName, description, e, false)
+}
+
+// OverrideSubcommand replaces the subcommand with the full name provided, which is otherwise reported as a conflict by
+// Command.WithSubcommand. If there is no such subcommand yet, it is added. It otherwise behaves the same as
+// Command.WithSubcommand.
+func (c Command) OverrideSubcommand(fullName, description string, e Executor) Command {
+	return must(c.withSubcommand(fullName, description, e, true))
+}
+
+// OverrideErrorSubcommand replaces the subcommand with the full name provided by a subcommand with an ErrorExecutor. It
+// is otherwise the same as Command.OverrideSubcommand.
+func (c Command) OverrideErrorSubcommand(fullName, description string, e ErrorExecutor) Command {
+	return must(c.withSubcommand(fullName, description, e, true))
+}
+
+// withSubcommand adds a new subcommand to the command, of which the executor is either an Executor or ErrorExecutor. The
+// caller of the caller is recorded as the location the subcommand was defined at. An existing subcommand with the same
+// name is only replaced if override is true.
+func (c Command) withSubcommand(fullName, description string, e any, override bool) (Command, error) {
+	path := "/" + c.name + " " + fullName
+	source := callerSource(2)
+	var errs ValidationErrors
+	if c.executor != nil {
+		errs = append(errs, ValidationError{Path: path, Message: "subcommands and main executor are mutually exclusive"})
 	}
 
 	name := fullName
@@ -88,26 +230,98 @@ func (c Command) WithSubcommand(fullName, description string, e Executor) Comman
 	if names := strings.SplitN(fullName, " ", 2); len(names) > 1 {
 		name = names[1]
 		groupName = names[0]
-		mustMatch(groupName)
 
 		// The subcommand group must first be registered
 		if _, ok := c.subGroups[groupName]; !ok {
-			panic(fmt.Sprintf("Non-existent subcommand group: %s", groupName))
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("non-existent subcommand group %q", groupName)})
 		}
 	}
-	mustMatch(name)
-	if len(name) < nameMinLength || len(name) > nameMaxLength {
-		panic(fmt.Sprintf("subcommand name must be equal to or between %v and %v characters in length", nameMinLength, nameMaxLength))
-	} else if groupName != "" && (len(groupName) < nameMinLength || len(groupName) > nameMaxLength) {
-		panic(fmt.Sprintf("subcommand group name must be equal to or between %v and %v characters in length", nameMinLength, nameMaxLength))
+	errs = append(errs, validateName(path, name)...)
+	errs = append(errs, validateDescription(path, description)...)
+	errs = append(errs, duplicateOptions(path, e)...)
+	existing, exists := c.subcommands[fullName]
+	if exists && !override {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("subcommand is defined both at %s with executor %T and at %s with executor %T", existing.source, existing.executor, source, e)})
+	} else if _, ok := c.subGroups[fullName]; ok {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("subcommand defined at %s has the same name as the subcommand group defined at %s", source, c.groupSources[fullName])})
+	}
+	if len(errs) > 0 {
+		return c, errs
 	}
 
+	if !exists {
+		c.order = append(c.order[:len(c.order):len(c.order)], fullName)
+	}
+	c.subcommands = cloneMap(c.subcommands)
 	c.subcommands[fullName] = Subcommand{
 		name:        name,
 		description: description,
 		group:       groupName,
 		executor:    e,
+		source:      source,
+	}
+	return c, nil
+}
+
+// WithSortedSubcommands makes the subcommands and subcommand groups of the command show up sorted by name. By default,
+// they show up in the order in which they were added to the command.
+func (c Command) WithSortedSubcommands() Command {
+	c.sorted = true
+	return c
+}
+
+// WithMiddleware returns the command with the middleware provided added to it. The middleware will wrap the execution
+// of the main executor and all subcommands of the command, within the middleware of the handler.
+func (c Command) WithMiddleware(m ...Middleware) Command {
+	c.middleware = append(c.middleware[:len(c.middleware):len(c.middleware)], m...)
+	return c
+}
+
+// WithGroupMiddleware adds middleware to a subcommand group, which will wrap the execution of all subcommands within the
+// group, within the middleware of the command. The subcommand group must first be added using
+// Command.WithSubcommandGroup.
+func (c Command) WithGroupMiddleware(group string, m ...Middleware) Command {
+	if _, ok := c.subGroups[group]; !ok {
+		panic(fmt.Sprintf("Non-existent subcommand group: %s", group))
+	}
+	existing := c.groupMiddleware[group]
+	c.groupMiddleware = cloneMap(c.groupMiddleware)
+	c.groupMiddleware[group] = append(existing[:len(existing):len(existing)], m...)
+	return c
+}
+
+// WithGuilds sets the guilds the command is registered in by Handler.Sync. If no guilds are set, which is the default,
+// the command is registered globally.
+func (c Command) WithGuilds(guilds ...discord.GuildID) Command {
+	c.scope = append([]discord.GuildID(nil), guilds...)
+	return c
+}
+
+// WithEphemeralAutoDefer makes the response the handler defers for the command ephemeral, when its executor takes too
+// long to respond. See Handler.WithAutoDefer.
+func (c Command) WithEphemeralAutoDefer() Command {
+	c.ephemeralDefer = true
+	return c
+}
+
+// WithCooldown returns the command with the cooldown provided. The cooldown is shared by the main executor and all
+// subcommands of the command.
+func (c Command) WithCooldown(cooldown Cooldown) Command {
+	c.cooldown = &cooldown
+	return c
+}
+
+// WithSubcommandCooldown adds a cooldown to a single subcommand of the command, which must first be added using
+// Command.WithSubcommand. The full name of the subcommand must be provided, in the same way as for
+// Command.WithSubcommand. The cooldown applies in addition to the cooldown of the command itself.
+func (c Command) WithSubcommandCooldown(fullName string, cooldown Cooldown) Command {
+	sub, ok := c.subcommands[fullName]
+	if !ok {
+		panic(fmt.Sprintf("Non-existent subcommand: %s", fullName))
 	}
+	sub.cooldown = &cooldown
+	c.subcommands = cloneMap(c.subcommands)
+	c.subcommands[fullName] = sub
 	return c
 }
 
@@ -118,6 +332,27 @@ func (c Command) WithoutDefaultEnabled() Command {
 	return c
 }
 
+// WithDefaultMemberPermissions sets the permissions a member needs to have to be able to execute the command by default.
+// Guild admins are able to change who can execute the command afterwards. Passing 0 will make the command available to
+// admins only.
+func (c Command) WithDefaultMemberPermissions(perms discord.Permissions) Command {
+	c.defaultMemberPermissions = &perms
+	return c
+}
+
+// WithoutDMPermission will make it so the command cannot be executed in the direct messages of the bot. This only
+// applies to global commands, as guild commands are never available in direct messages.
+func (c Command) WithoutDMPermission() Command {
+	c.dmPermission = false
+	return c
+}
+
+// WithNSFW marks the command as age-restricted. It will only be available in channels marked as NSFW.
+func (c Command) WithNSFW() Command {
+	c.nsfw = true
+	return c
+}
+
 // Name is what the player will type to execute the slash command: /<name>
 func (c Command) Name() string {
 	return c.name
@@ -134,54 +369,157 @@ func (c Command) Guild() discord.GuildID {
 	return c.guild
 }
 
-// Subcommands returns all registered subcommands of the command.
+// Scope returns the guilds the command is registered in by Handler.Sync, as set using Command.WithGuilds. If the
+// command is registered globally, nil is returned.
+func (c Command) Scope() []discord.GuildID {
+	if len(c.scope) == 0 {
+		return nil
+	}
+	return append([]discord.GuildID(nil), c.scope...)
+}
+
+// registeredName returns the name the command is registered to discord with.
+func (c Command) registeredName() string {
+	return c.prefix + c.name
+}
+
+// inScope returns whether the command should be registered in the guild provided by Handler.Sync, or globally if
+// discord.NullGuildID is provided.
+func (c Command) inScope(guildId discord.GuildID) bool {
+	if !guildId.IsValid() {
+		return len(c.scope) == 0
+	}
+	for _, id := range c.scope {
+		if id == guildId {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMemberPermissions returns the permissions a member needs to execute the command by default. If no permissions
+// were set, the second return value will be false.
+func (c Command) DefaultMemberPermissions() (discord.Permissions, bool) {
+	if c.defaultMemberPermissions == nil {
+		return 0, false
+	}
+	return *c.defaultMemberPermissions, true
+}
+
+// DMPermission returns whether the command can be executed in the direct messages of the bot.
+func (c Command) DMPermission() bool {
+	return c.dmPermission
+}
+
+// NSFW returns whether the command is age-restricted, and can only be executed in channels marked as NSFW.
+func (c Command) NSFW() bool {
+	return c.nsfw
+}
+
+// Subcommands returns all registered subcommands of the command, in the order in which they show up in discord.
+// Subcommands within a subcommand group are returned at the position of the group.
 func (c Command) Subcommands() (subcommands []Subcommand) {
-	for _, sub := range c.subcommands {
-		subcommands = append(subcommands, sub)
+	top, groups := c.subcommandLayout()
+	for _, name := range top {
+		if sub, ok := c.subcommands[name]; ok {
+			subcommands = append(subcommands, sub)
+			continue
+		}
+		for _, fullName := range groups[name] {
+			subcommands = append(subcommands, c.subcommands[fullName])
+		}
 	}
 	return
 }
 
+// subcommandLayout returns the full names of the subcommands directly within the command and the names of its
+// subcommand groups, together with the full names of the subcommands within every group, all in the order in which they
+// show up in discord. Subcommand groups without subcommands are left out.
+func (c Command) subcommandLayout() (top []string, groups map[string][]string) {
+	groups = map[string][]string{}
+	for _, name := range c.order {
+		if sub, ok := c.subcommands[name]; ok && sub.group != "" {
+			groups[sub.group] = append(groups[sub.group], name)
+		}
+	}
+	for _, name := range c.order {
+		if sub, ok := c.subcommands[name]; ok && sub.group == "" {
+			top = append(top, name)
+		} else if len(groups[name]) > 0 {
+			top = append(top, name)
+		}
+	}
+	if c.sorted {
+		sort.Strings(top)
+		for _, names := range groups {
+			sort.Strings(names)
+		}
+	}
+	return top, groups
+}
+
+// guildOnly returns whether all executors of the command embed GuildOnly, in which case the command should not be
+// available in direct messages at all.
+func (c Command) guildOnly() bool {
+	if c.executor != nil {
+		_, ok := c.executor.(guildOnly)
+		return ok
+	}
+	for _, sub := range c.subcommands {
+		if _, ok := sub.executor.(guildOnly); !ok {
+			return false
+		}
+	}
+	return len(c.subcommands) > 0
+}
+
 // marshal will generate the command with all it's parameters, so it is ready to be sent through the discord API.
-func (c Command) marshal() api.CreateCommandData {
+func (c Command) marshal() commandData {
 	options := discord.CommandOptions{}
-	groups := map[string]*discord.SubcommandGroupOption{}
 	if c.executor != nil {
 		for _, opt := range makeCommandOptions(c.executor) {
 			options = append(options, opt)
 		}
 	} else {
-		for _, subcommand := range c.subcommands {
-			sub := &discord.SubcommandOption{
-				OptionName:  subcommand.name,
-				Description: subcommand.description,
-				Required:    false, // ???
-				Options:     makeCommandOptions(subcommand.executor),
+		top, groups := c.subcommandLayout()
+		for _, name := range top {
+			if sub, ok := c.subcommands[name]; ok {
+				options = append(options, c.marshalSubcommand(sub))
+				continue
 			}
-
-			if subcommand.group != "" {
-				if group, ok := groups[subcommand.group]; ok {
-					group.Subcommands = append(group.Subcommands, sub)
-				} else {
-					group = &discord.SubcommandGroupOption{
-						OptionName:  subcommand.group,
-						Description: c.subGroups[subcommand.group],
-						Required:    false,
-						Subcommands: []*discord.SubcommandOption{sub},
-					}
-					options = append(options, group)
-					groups[subcommand.group] = group
-				}
-			} else {
-				options = append(options, sub)
+			group := &discord.SubcommandGroupOption{
+				OptionName:  name,
+				Description: c.subGroups[name],
+				Required:    false,
+			}
+			for _, fullName := range groups[name] {
+				group.Subcommands = append(group.Subcommands, c.marshalSubcommand(c.subcommands[fullName]))
 			}
+			options = append(options, group)
 		}
 	}
 
-	return api.CreateCommandData{
-		Type:        discord.ChatInputCommand,
-		Name:        c.name,
-		Description: c.description,
-		Options:     options,
+	return commandData{
+		CreateCommandData: api.CreateCommandData{
+			Type:                discord.ChatInputCommand,
+			Name:                c.registeredName(),
+			Description:         c.description,
+			Options:             options,
+			NoDefaultPermission: !c.defaultEnabled,
+		},
+		DefaultMemberPermissions: c.defaultMemberPermissions,
+		DMPermission:             c.dmPermission && !c.guildOnly(),
+		NSFW:                     c.nsfw,
+	}
+}
+
+// marshalSubcommand generates the option of a subcommand of the command, so it is ready to be sent through the discord
+// API.
+func (c Command) marshalSubcommand(subcommand Subcommand) *discord.SubcommandOption {
+	return &discord.SubcommandOption{
+		OptionName:  subcommand.name,
+		Description: subcommand.description,
+		Required:    false, // ???
+		Options:     makeCommandOptions(subcommand.executor),
 	}
 }
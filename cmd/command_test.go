@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// adminCommand returns a command with subcommands and subcommand groups added out of order.
+func adminCommand() Command {
+	return New("admin", "Admin commands.").
+		WithSubcommand("reload", "Reloads the bot.", testExecutor{}).
+		WithSubcommandGroup("user", "User commands.").
+		WithSubcommand("user kick", "Kicks a user.", testExecutor{}).
+		WithSubcommand("user ban", "Bans a user.", testExecutor{}).
+		WithSubcommandGroup("empty", "A group without subcommands.").
+		WithSubcommand("about", "Shows information about the bot.", testExecutor{}).
+		WithSubcommand("user warn", "Warns a user.", testExecutor{})
+}
+
+func TestMarshalGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		command Command
+	}{
+		{name: "insertion_order", command: adminCommand()},
+		{name: "sorted", command: adminCommand().WithSortedSubcommands()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := json.MarshalIndent(test.command.marshal(), "", "\t")
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", test.name+".golden")
+			if *update {
+				if err = os.WriteFile(path, got, 0644); err != nil {
+					t.Fatalf("update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("marshal() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSubcommandLayout(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    Command
+		wantTop    []string
+		wantGroups map[string][]string
+	}{{
+		name:       "insertion order",
+		command:    adminCommand(),
+		wantTop:    []string{"reload", "user", "about"},
+		wantGroups: map[string][]string{"user": {"user kick", "user ban", "user warn"}},
+	}, {
+		name:       "sorted",
+		command:    adminCommand().WithSortedSubcommands(),
+		wantTop:    []string{"about", "reload", "user"},
+		wantGroups: map[string][]string{"user": {"user ban", "user kick", "user warn"}},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			top, groups := test.command.subcommandLayout()
+			if !reflect.DeepEqual(top, test.wantTop) || !reflect.DeepEqual(groups, test.wantGroups) {
+				t.Errorf("subcommandLayout() = %v, %v, want %v, %v", top, groups, test.wantTop, test.wantGroups)
+			}
+		})
+	}
+}
+
+func TestBuilderCopies(t *testing.T) {
+	base := New("admin", "Admin commands.").
+		WithSubcommandGroup("user", "User commands.").
+		WithSubcommand("user ban", "Bans a user.", testExecutor{})
+	kick := base.WithSubcommand("user kick", "Kicks a user.", testExecutor{})
+	warn := base.WithSubcommand("user warn", "Warns a user.", testExecutor{}).
+		WithSubcommandCooldown("user warn", Cooldown{}).
+		WithGroupMiddleware("user", nil)
+
+	for _, test := range []struct {
+		name    string
+		command Command
+		want    []string
+	}{
+		{name: "base", command: base, want: []string{"user ban"}},
+		{name: "kick", command: kick, want: []string{"user ban", "user kick"}},
+		{name: "warn", command: warn, want: []string{"user ban", "user warn"}},
+	} {
+		_, groups := test.command.subcommandLayout()
+		if got := groups["user"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: subcommands = %v, want %v", test.name, got, test.want)
+		}
+	}
+	if len(base.groupMiddleware) != 0 {
+		t.Errorf("base: group middleware = %v, want none", base.groupMiddleware)
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// initialResponseWindow is the time discord gives the bot to send the initial response to an interaction.
+	initialResponseWindow = 3 * time.Second
+	// tokenLifetime is the time the token of an interaction stays valid after the interaction was created. Followup
+	// messages can be sent and responses can be edited for as long as the token is valid.
+	tokenLifetime = 15 * time.Minute
+)
+
+// ErrInteractionExpired is returned when trying to send or edit messages with an interaction token that is no longer
+// valid, because the interaction was created more than 15 minutes ago.
+var ErrInteractionExpired = errors.New("the interaction token has expired")
+
+// handlerKey is the context key of the Handler handling an interaction.
+type handlerKey struct{}
+
+// initialResponseKey is the context key of the initialResponse of an interaction.
+type initialResponseKey struct{}
+
+// initialResponse holds the deadline for the initial response to an interaction.
+type initialResponse struct {
+	deadline time.Time
+	done     <-chan struct{}
+}
+
+// InitialResponseDeadline returns the deadline for the initial response to the interaction the context belongs to,
+// together with a channel that is closed once it has passed. After the deadline, Interaction.Respond and
+// Interaction.DeferResponse will fail, and only the token of the interaction can be used. The last return value is false
+// if the context does not belong to an interaction.
+func InitialResponseDeadline(ctx context.Context) (time.Time, <-chan struct{}, bool) {
+	r, ok := ctx.Value(initialResponseKey{}).(initialResponse)
+	if !ok {
+		return time.Time{}, nil, false
+	}
+	return r.deadline, r.done, true
+}
+
+// newInteractionContext returns the context of an interaction created at the time provided. Its deadline is the moment
+// the token of the interaction expires, and it carries the deadline for the initial response.
+func newInteractionContext(parent context.Context, created time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(parent, created.Add(tokenLifetime))
+
+	initialCtx, initialCancel := context.WithDeadline(ctx, created.Add(initialResponseWindow))
+	ctx = context.WithValue(ctx, initialResponseKey{}, initialResponse{
+		deadline: created.Add(initialResponseWindow),
+		done:     initialCtx.Done(),
+	})
+	return ctx, func() {
+		initialCancel()
+		cancel()
+	}
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// CooldownBucket decides which executions of a command share the same cooldown.
+type CooldownBucket uint8
+
+const (
+	// BucketUser gives every user their own cooldown, shared across all guilds and direct messages.
+	BucketUser CooldownBucket = iota
+	// BucketMember gives every user a separate cooldown in every guild. In direct messages, it acts like BucketUser.
+	BucketMember
+	// BucketChannel makes all users in the same channel share a cooldown.
+	BucketChannel
+	// BucketGuild makes all users in the same guild share a cooldown. In direct messages, it acts like BucketUser.
+	BucketGuild
+)
+
+// Cooldown limits how often a command or subcommand can be executed. It can be added to a command using
+// Command.WithCooldown and to a subcommand using Command.WithSubcommandCooldown.
+type Cooldown struct {
+	// Bucket decides which executions share the same cooldown.
+	Bucket CooldownBucket
+	// Uses is the amount of times the command can be executed within Period in the same bucket. If Uses or Period is
+	// 0, the command can be executed as often as desired.
+	Uses int
+	// Period is the period in which the command can be executed Uses times.
+	Period time.Duration
+	// MaxConcurrency is the maximum amount of executions of the command that may run at the same time, across all
+	// users. When it is reached, further executions are refused with Messages.ConcurrencyLimit until one finishes. A
+	// value of 0 means there is no limit.
+	MaxConcurrency int
+}
+
+// key returns the key under which the uses of the cooldown are stored for the interaction provided.
+func (c Cooldown) key(name string, i *Interaction) string {
+	switch {
+	case c.Bucket == BucketMember && i.InGuild():
+		return name + "/member/" + i.guildId.String() + "/" + i.user.ID.String()
+	case c.Bucket == BucketChannel:
+		return name + "/channel/" + i.channelId.String()
+	case c.Bucket == BucketGuild && i.InGuild():
+		return name + "/guild/" + i.guildId.String()
+	}
+	return name + "/user/" + i.user.ID.String()
+}
+
+// CooldownStore stores how often commands have been executed, to enforce cooldowns. Implementations must be safe for
+// concurrent use.
+type CooldownStore interface {
+	// Take records a use of the key provided, if it has been used less than uses times in the current period. If the
+	// limit has been reached, nothing is recorded and the time until the key can be used again is returned.
+	Take(key string, uses int, period time.Duration) (retryAfter time.Duration, err error)
+}
+
+// cooldownWindow holds the amount of uses of a key within a period.
+type cooldownWindow struct {
+	Uses  int       `json:"uses"`
+	Reset time.Time `json:"reset"`
+}
+
+// MemoryCooldownStore is a CooldownStore that keeps all cooldowns in memory. Cooldowns are lost when the process stops.
+type MemoryCooldownStore struct {
+	mu      sync.Mutex
+	windows map[string]cooldownWindow
+	lastGC  time.Time
+}
+
+// NewMemoryCooldownStore returns a new, empty MemoryCooldownStore.
+func NewMemoryCooldownStore() *MemoryCooldownStore {
+	return &MemoryCooldownStore{windows: map[string]cooldownWindow{}}
+}
+
+// Take ...
+func (s *MemoryCooldownStore) Take(key string, uses int, period time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.take(key, uses, period, time.Now()), nil
+}
+
+// take records a use of the key provided at the time provided. s.mu must be held while calling take.
+func (s *MemoryCooldownStore) take(key string, uses int, period time.Duration, now time.Time) time.Duration {
+	// Expired windows are removed every once in a while, so that the store does not keep growing.
+	if now.Sub(s.lastGC) > time.Minute {
+		for k, w := range s.windows {
+			if !now.Before(w.Reset) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastGC = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.Reset) {
+		w = cooldownWindow{Reset: now.Add(period)}
+	}
+	if w.Uses >= uses {
+		return w.Reset.Sub(now)
+	}
+	w.Uses++
+	s.windows[key] = w
+	return 0
+}
+
+// cooldownSaveInterval is how often a FileCooldownStore writes its cooldowns to its file if they have changed. It is
+// only changed by tests.
+var cooldownSaveInterval = 5 * time.Second
+
+// FileCooldownStore is a CooldownStore that keeps all cooldowns in memory, but also writes them to a JSON file, so that
+// cooldowns survive restarts. Changed cooldowns are written every few seconds in the background, and when
+// FileCooldownStore.Flush or FileCooldownStore.Close is called. Handler.Close flushes the store of the handler.
+type FileCooldownStore struct {
+	mem  *MemoryCooldownStore
+	path string
+
+	// saveMu is held while writing the file, so that writes never overlap.
+	saveMu sync.Mutex
+	// dirty is set when the cooldowns have changed since they were last written. err is the error of the last write
+	// in the background, which is returned by the next call to Take. Both are guarded by mem.mu.
+	dirty bool
+	err   error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	stopped   chan struct{}
+}
+
+// NewFileCooldownStore returns a new FileCooldownStore that stores cooldowns in the file at the path provided. If the
+// file exists, the cooldowns in it are loaded.
+func NewFileCooldownStore(path string) (*FileCooldownStore, error) {
+	s := &FileCooldownStore{
+		mem:     NewMemoryCooldownStore(),
+		path:    path,
+		closed:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	} else if err == nil {
+		if err = json.Unmarshal(b, &s.mem.windows); err != nil {
+			return nil, err
+		}
+	}
+	go s.saveLoop()
+	return s, nil
+}
+
+// Take ...
+func (s *FileCooldownStore) Take(key string, uses int, period time.Duration) (time.Duration, error) {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	retryAfter := s.mem.take(key, uses, period, time.Now())
+	if retryAfter > 0 {
+		return retryAfter, nil
+	}
+	s.dirty = true
+	err := s.err
+	s.err = nil
+	return 0, err
+}
+
+// Flush writes all cooldowns to the file of the store if they have changed since they were last written.
+func (s *FileCooldownStore) Flush() error {
+	return s.save()
+}
+
+// Close stops writing cooldowns in the background and writes them to the file of the store a final time. Cooldowns
+// taken after Close are only written by FileCooldownStore.Flush.
+func (s *FileCooldownStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	<-s.stopped
+	return s.save()
+}
+
+// saveLoop writes the cooldowns to the file of the store periodically until the store is closed.
+func (s *FileCooldownStore) saveLoop() {
+	defer close(s.stopped)
+	t := time.NewTicker(cooldownSaveInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.save(); err != nil {
+				s.mem.mu.Lock()
+				s.err = err
+				s.mem.mu.Unlock()
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// save writes all cooldowns to the file of the store if they have changed. The file is replaced atomically, so that it
+// never ends up partially written. Only encoding the cooldowns is done while holding s.mem.mu, so that Take is not
+// blocked while writing.
+func (s *FileCooldownStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mem.mu.Lock()
+	if !s.dirty {
+		s.mem.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(s.mem.windows)
+	s.dirty = err != nil
+	s.mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = writeFileAtomic(s.path, b); err != nil {
+		// The cooldowns are written again the next time, even if they do not change in the meantime.
+		s.mem.mu.Lock()
+		s.dirty = true
+		s.mem.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// concurrencyLimiter keeps track of the amount of executions of commands that are running at the same time.
+type concurrencyLimiter struct {
+	mu      sync.Mutex
+	running map[string]int
+}
+
+// acquire registers a new execution of the key provided, if less than max executions are running. It returns false if
+// this is not the case.
+func (l *concurrencyLimiter) acquire(key string, max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.running[key] >= max {
+		return false
+	}
+	l.running[key]++
+	return true
+}
+
+// release registers that an execution of the key provided has finished.
+func (l *concurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.running[key]--; l.running[key] <= 0 {
+		delete(l.running, key)
+	}
+}
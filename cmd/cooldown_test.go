@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCooldownStore(t *testing.T) {
+	s := NewMemoryCooldownStore()
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		key   string
+		after time.Duration
+		want  time.Duration
+	}{
+		{name: "first use", key: "a", want: 0},
+		{name: "second use", key: "a", after: time.Second, want: 0},
+		{name: "on cooldown", key: "a", after: 2 * time.Second, want: 58 * time.Second},
+		{name: "other key", key: "b", after: 2 * time.Second, want: 0},
+		{name: "next period", key: "a", after: time.Minute, want: 0},
+	}
+	for _, test := range tests {
+		if got := s.take(test.key, 2, time.Minute, start.Add(test.after)); got != test.want {
+			t.Errorf("%s: take() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := concurrencyLimiter{running: map[string]int{}}
+	if !l.acquire("a", 1) {
+		t.Fatal("acquire() = false for a key that is not running")
+	}
+	if l.acquire("a", 1) {
+		t.Error("acquire() = true for a key at its limit")
+	}
+	if !l.acquire("b", 1) {
+		t.Error("acquire() = false for another key")
+	}
+	l.release("a")
+	if !l.acquire("a", 1) {
+		t.Error("acquire() = false after releasing the key")
+	}
+}
+
+// takeFile takes a use of the key provided from a FileCooldownStore, with a single use per hour.
+func takeFile(t *testing.T, s *FileCooldownStore, key string) time.Duration {
+	t.Helper()
+	retryAfter, err := s.Take(key, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	return retryAfter
+}
+
+func TestFileCooldownStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cooldowns.json")
+	s, err := NewFileCooldownStore(path)
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Flush wrote the file without any cooldowns changing: %v", err)
+	}
+
+	takeFile(t, s, "a")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The cooldowns written when closing the store are loaded by a new store.
+	s, err = NewFileCooldownStore(path)
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+	defer s.Close()
+	if retryAfter := takeFile(t, s, "a"); retryAfter <= 0 {
+		t.Errorf("Take() = %v after reloading, want a positive duration", retryAfter)
+	}
+	if retryAfter := takeFile(t, s, "b"); retryAfter != 0 {
+		t.Errorf("Take() = %v for another key, want 0", retryAfter)
+	}
+}
+
+// withSaveInterval makes FileCooldownStores created during the test write their cooldowns every millisecond.
+func withSaveInterval(t *testing.T) {
+	interval := cooldownSaveInterval
+	cooldownSaveInterval = time.Millisecond
+	t.Cleanup(func() {
+		cooldownSaveInterval = interval
+	})
+}
+
+func TestFileCooldownStoreBackgroundSave(t *testing.T) {
+	withSaveInterval(t)
+	path := filepath.Join(t.TempDir(), "cooldowns.json")
+	s, err := NewFileCooldownStore(path)
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+	defer s.Close()
+
+	takeFile(t, s, "a")
+	waitFor(t, "cooldowns to be written", func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+
+	// The store was not closed, so the cooldown can only be known from the file written in the background.
+	loaded, err := NewFileCooldownStore(path)
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+	defer loaded.Close()
+	if retryAfter := takeFile(t, loaded, "a"); retryAfter <= 0 {
+		t.Errorf("Take() = %v after reloading, want a positive duration", retryAfter)
+	}
+}
+
+func TestFileCooldownStoreError(t *testing.T) {
+	withSaveInterval(t)
+	// The directory of the file does not exist, so the cooldowns can never be written.
+	s, err := NewFileCooldownStore(filepath.Join(t.TempDir(), "missing", "cooldowns.json"))
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+
+	takeFile(t, s, "a")
+	waitFor(t, "the write to fail", func() bool {
+		s.mem.mu.Lock()
+		defer s.mem.mu.Unlock()
+		return s.err != nil
+	})
+	// Closing the store stops writing in the background, so the error is not set again below.
+	if err := s.Close(); err == nil {
+		t.Error("Close() returned no error")
+	}
+
+	// The error of the background write is returned by the next use that is recorded, and only once.
+	if retryAfter, err := s.Take("a", 1, time.Hour); retryAfter <= 0 || err != nil {
+		t.Errorf("Take() = %v, %v for a key on cooldown, want a positive duration and no error", retryAfter, err)
+	}
+	if _, err := s.Take("b", 1, time.Hour); err == nil {
+		t.Error("Take() returned no error after failing to write the cooldowns")
+	}
+	if _, err := s.Take("c", 1, time.Hour); err != nil {
+		t.Errorf("Take() = %v, want the error to be returned only once", err)
+	}
+	if err := s.Flush(); err == nil {
+		t.Error("Flush() returned no error")
+	}
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrorHandler is a function that handles an error returned by an ErrorExecutor or middleware. It is passed the
+// Execution of the command that failed.
+type ErrorHandler func(e Execution, err error)
+
+// UserError is an error of which the message is meant to be shown to the user executing the command, for example
+// because the user provided an invalid parameter. It may be wrapped in other errors.
+type UserError struct {
+	// Message is the message shown to the user.
+	Message string
+	// Err is the underlying error, if any. It is never shown to the user.
+	Err error
+}
+
+// UserErrorf returns a new UserError with the formatted message provided.
+func UserErrorf(format string, args ...any) error {
+	return UserError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Error ...
+func (e UserError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap ...
+func (e UserError) Unwrap() error {
+	return e.Err
+}
+
+// ErrAlreadyResponded is returned when a response is sent to an interaction that has already been responded to.
+var ErrAlreadyResponded = errors.New("cannot send multiple responses to the same interaction")
+
+// ErrUnknownCommand is returned when removing a command that the Handler does not have.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// ErrHandlerClosed is returned when using a Handler that has been closed using Handler.Close.
+var ErrHandlerClosed = errors.New("the command handler has been closed")
+
+// ErrCloseInExecutor is returned when Handler.Close is called with the context of an interaction handled by the
+// Handler, as it would wait for the executor calling it forever.
+var ErrCloseInExecutor = errors.New("the command handler cannot be closed by an executor it is running")
+
+// ParameterError is passed to the ErrorHandler when an option of an interaction could not be decoded into the parameter
+// of the executor with the same name. The executor is not ran in this case.
+type ParameterError struct {
+	// Name is the name of the option.
+	Name string
+	// Err is the reason the option could not be decoded.
+	Err error
+}
+
+// Error ...
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("parameter %s: %v", e.Name, e.Err)
+}
+
+// Unwrap ...
+func (e *ParameterError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is passed to the ErrorHandler when an executor or middleware panics. The panic is recovered, so that it
+// does not take down the process.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked, at the moment the panic was recovered.
+	Stack []byte
+}
+
+// Error ...
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// execute runs the ExecuteFunc provided. If it panics, the panic is recovered and returned as a *PanicError.
+func execute(f ExecuteFunc, e Execution) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f(e)
+}
+
+// RespondError is the default ErrorHandler of a Handler. It sends an ephemeral response to the interaction, or an
+// ephemeral followup message if a response has already been sent. If the error is a UserError, its message is shown to
+// the user. Other errors are logged, and a generic message is shown instead.
+func (h *Handler) RespondError(e Execution, err error) {
+	msg := h.messages.InternalError
+	log := e.Interaction.Logger()
+	var userErr UserError
+	var paramErr *ParameterError
+	var panicErr *PanicError
+	if errors.As(err, &userErr) {
+		msg = userErr.Message
+		log.Debugf("Command failed with user error: %v", err)
+	} else if errors.As(err, &paramErr) {
+		log.Warnf("Failed to decode parameters: %v", err)
+	} else if errors.As(err, &panicErr) {
+		log.Errorf("Recovered from panic executing command: %v\n%s", panicErr.Value, panicErr.Stack)
+	} else {
+		log.Errorf("Error executing command: %v", err)
+	}
+
+	// Discord does not accept empty messages, so an empty UserError message is replaced.
+	if msg == "" {
+		msg = h.messages.InternalError
+	}
+	response := MessageResponse{Content: msg, Ephemeral: true}
+	if e.Interaction.Responded() {
+		_, err = e.Interaction.followup().Create(response)
+	} else {
+		_, err = e.Interaction.Respond(response)
+	}
+	if err != nil {
+		log.Errorf("Failed to send error response: %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/diamondburned/arikawa/v3/discord"
 	"github.com/diamondburned/arikawa/v3/utils/json/option"
@@ -18,14 +20,45 @@ type Executor interface {
 	Run(interaction *Interaction)
 }
 
-// GuildOnly is a struct that can be embedded in a command handler to make it runnable only in guilds. When the command
-// is ran in the bot's direct messages, a response containing an error message will be sent, telling the user
-type GuildOnly struct {
-	api API
+// ErrorExecutor is an alternative to Executor for executors that can fail. Errors returned by Run are passed to the
+// error handler of the Handler, which by default responds to the user with an error message. Use UserError to return
+// errors of which the message should be shown to the user.
+type ErrorExecutor interface {
+	// Run is the function that will be called by the command handler when the command is being executed by a user. It
+	// is the same as Executor.Run, except that it can return an error.
+	Run(interaction *Interaction) error
 }
 
-// makeCommandOptions determines all parameters for a given Executor. The executor must be a struct.
-func makeCommandOptions(e Executor) []discord.CommandOptionValue {
+// GuildOnly is a struct that can be embedded in a command executor to make it runnable only in guilds. When the command
+// is ran in the bot's direct messages, a response containing an error message will be sent, telling the user that the
+// command cannot be used there. If the main executor or all subcommand executors of a command embed GuildOnly, the
+// command will also not be shown in direct messages.
+type GuildOnly struct{}
+
+func (GuildOnly) guildOnly() {}
+
+// DMOnly is a struct that can be embedded in a command executor to make it runnable only in the bot's direct messages.
+// When the command is ran in a guild, a response containing an error message will be sent instead.
+type DMOnly struct{}
+
+func (DMOnly) dmOnly() {}
+
+// OwnerOnly is a struct that can be embedded in a command executor to make it runnable only by the owner of the
+// application, or the members of its team if it is owned by a team. When the command is ran by anyone else, a response
+// containing an error message will be sent instead.
+type OwnerOnly struct{}
+
+func (OwnerOnly) ownerOnly() {}
+
+// guildOnly, dmOnly and ownerOnly are implemented by executors that embed GuildOnly, DMOnly and OwnerOnly respectively.
+type (
+	guildOnly interface{ guildOnly() }
+	dmOnly    interface{ dmOnly() }
+	ownerOnly interface{ ownerOnly() }
+)
+
+// makeCommandOptions determines all parameters for a given Executor or ErrorExecutor. The executor must be a struct.
+func makeCommandOptions(e any) []discord.CommandOptionValue {
 	refl := reflect.TypeOf(e)
 	if refl.Kind() != reflect.Struct {
 		panic("command executor must be a struct")
@@ -45,9 +78,9 @@ func makeCommandOptions(e Executor) []discord.CommandOptionValue {
 		name := strings.ToLower(field.Name)
 		desc := field.Tag.Get("description")
 
-		if len(name) < nameMinLength || len(name) > nameMaxLength {
+		if utf8.RuneCountInString(name) < nameMinLength || utf8.RuneCountInString(name) > nameMaxLength {
 			panic(fmt.Sprintf("parameter name must be equal to or between %v and %v characters in length", nameMinLength, nameMaxLength))
-		} else if len(desc) < descMinLength || len(desc) > descMaxLength {
+		} else if utf8.RuneCountInString(desc) < descMinLength || utf8.RuneCountInString(desc) > descMaxLength {
 			panic(fmt.Sprintf("parameter description must be equal to or between %v and %v characters in length", descMinLength, descMaxLength))
 		}
 
@@ -131,3 +164,95 @@ func makeCommandOptions(e Executor) []discord.CommandOptionValue {
 	}
 	return opts
 }
+
+// parameterize creates a new instance of the executor provided, with all parameters set to the values of the options
+// provided. A *ParameterError is returned if an option could not be decoded into its parameter.
+func parameterize(e any, options discord.CommandInteractionOptions) (any, error) {
+	refl := reflect.New(reflect.TypeOf(e)).Elem()
+	for _, option := range options {
+		field := refl.FieldByNameFunc(func(s string) bool {
+			return option.Name == strings.ToLower(s)
+		})
+		if !field.IsValid() {
+			return nil, &ParameterError{Name: option.Name, Err: errors.New("no parameter with this name exists")}
+		}
+
+		// Determine what to cast the command option to depending on the parameter type
+		instance := field.Interface()
+
+		actualType := instance
+		if opt, ok := instance.(optional); ok {
+			actualType = opt.get()
+		}
+
+		var r interface{}
+		var err error
+
+		switch actualType.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			var temp int64
+			temp, err = option.IntValue()
+			// Determine which type of int the type actually is, and cast it
+			switch actualType.(type) {
+			case int:
+				r = int(temp)
+			case uint:
+				r = uint(temp)
+			case int8:
+				r = int8(temp)
+			case uint8:
+				r = uint8(temp)
+			case int16:
+				r = int16(temp)
+			case uint16:
+				r = uint16(temp)
+			case int32:
+				r = int32(temp)
+			case uint32:
+				r = uint32(temp)
+			case int64:
+				r = int64(temp)
+			case uint64:
+				r = uint64(temp)
+			}
+		case float32, float64:
+			var temp float64
+			temp, err = option.FloatValue()
+			if _, ok := actualType.(float32); ok {
+				r = float32(temp)
+			} else {
+				r = temp
+			}
+		case string:
+			r = option.String()
+		case bool:
+			r, err = option.BoolValue()
+
+		case User, Role, Channel, Mentionable:
+			var temp discord.Snowflake
+			temp, err = option.SnowflakeValue()
+			switch actualType.(type) {
+			case User:
+				r = User(temp)
+			case Role:
+				r = Role(temp)
+			case Channel:
+				r = Channel(temp)
+			case Mentionable:
+				r = Mentionable(temp)
+			}
+
+		default:
+			err = fmt.Errorf("unrecognized parameter type: %s", field.Type())
+		}
+
+		if err != nil {
+			return nil, &ParameterError{Name: option.Name, Err: err}
+		}
+		if opt, ok := instance.(optional); ok {
+			r = opt.set(r)
+		}
+		field.Set(reflect.ValueOf(r))
+	}
+	return refl.Interface(), nil
+}
@@ -3,7 +3,9 @@ package cmd
 import (
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
+	"go.uber.org/atomic"
 	"sync"
+	"time"
 )
 
 // Followup ...
@@ -11,20 +13,58 @@ type Followup struct {
 	api              API
 	appId            discord.AppID
 	interactionToken string
+	expires          time.Time
+	// deferPending points to the deferPending field of the interaction. If true, the next message created will replace
+	// the loading state of the interaction.
+	deferPending *atomic.Bool
+	// deferEphemeral points to the deferEphemeral field of the interaction. It decides whether an ephemeral message may
+	// replace the loading state of the interaction.
+	deferEphemeral *atomic.Bool
 
 	locker sync.Mutex
 }
 
 // Create ...
 func (f *Followup) Create(response Response) (*discord.Message, error) {
+	if f.expired() {
+		return nil, ErrInteractionExpired
+	}
 	f.locker.Lock()
 	defer f.locker.Unlock()
 
+	// The first message sent after deferring the response replaces the loading state by editing the original response.
+	if m, ok := response.(MessageResponse); ok && f.deferPending.CAS(true, false) {
+		if m.Ephemeral && !f.deferEphemeral.Load() {
+			return f.createEphemeral(m)
+		}
+		msg, err := f.api.EditInteractionResponse(f.appId, f.interactionToken, m.editData())
+		if err != nil {
+			f.deferPending.Store(true)
+		}
+		return msg, err
+	}
+
 	return f.api.CreateInteractionFollowup(f.appId, f.interactionToken, *response.marshal().Data)
 }
 
+// createEphemeral sends an ephemeral message after the response to the interaction was deferred without being
+// ephemeral. Editing the deferred response would make the message public, so it is sent as a followup instead, after
+// which the loading state is removed by deleting the deferred response. f.locker must be held while calling
+// createEphemeral.
+func (f *Followup) createEphemeral(m MessageResponse) (*discord.Message, error) {
+	msg, err := f.api.CreateInteractionFollowup(f.appId, f.interactionToken, *m.marshal().Data)
+	if err != nil {
+		f.deferPending.Store(true)
+		return nil, err
+	}
+	return msg, f.api.DeleteInteractionResponse(f.appId, f.interactionToken)
+}
+
 // Edit ...
 func (f *Followup) Edit(messageId discord.MessageID, editedData api.EditInteractionResponseData) (*discord.Message, error) {
+	if f.expired() {
+		return nil, ErrInteractionExpired
+	}
 	f.locker.Lock()
 	defer f.locker.Unlock()
 
@@ -33,8 +73,16 @@ func (f *Followup) Edit(messageId discord.MessageID, editedData api.EditInteract
 
 // Delete ...
 func (f *Followup) Delete(messageId discord.MessageID) error {
+	if f.expired() {
+		return ErrInteractionExpired
+	}
 	f.locker.Lock()
 	defer f.locker.Unlock()
 
 	return f.api.DeleteInteractionFollowup(f.appId, messageId, f.interactionToken)
 }
+
+// expired returns whether the token of the interaction has expired, after which the Followup can no longer be used.
+func (f *Followup) expired() bool {
+	return !time.Now().Before(f.expires)
+}
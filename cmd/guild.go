@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"runtime/debug"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// GuildPolicy returns the commands that should be registered in a guild, which is called by the handler every time
+// the bot joins a guild or a guild becomes available. If no commands are returned, the commands of the guild are left
+// untouched. Commands the handler registered in the guild in another way, such as using Command.WithGuilds and
+// Handler.Sync, are kept.
+type GuildPolicy func(guild discord.Guild) []Command
+
+// handleGuildCreate registers the commands returned by the GuildPolicy of the handler in the guild of the event. It is
+// ran on a separate goroutine, as registering the commands requires multiple API calls.
+func (h *Handler) handleGuildCreate(discordAPI API, event *gateway.GuildCreateEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Errorf("Recovered from panic while registering commands in guild %v: %v\n%s", event.ID, r, debug.Stack())
+		}
+	}()
+	if event.Unavailable {
+		return
+	}
+	commands := h.guildPolicy(event.Guild)
+	if len(commands) == 0 {
+		return
+	}
+
+	h.commandsMu.RLock()
+	dev := h.devGuild.IsValid()
+	h.commandsMu.RUnlock()
+	if dev {
+		// All commands are registered in the development guild, which should not be overwritten by the commands of
+		// other guilds.
+		h.logger.Debugf("Not registering commands in guild %v in development mode", event.ID)
+		return
+	}
+
+	pending := make(map[string]Command, len(commands))
+	for _, cmd := range commands {
+		if existing, ok := pending[cmd.registeredName()]; ok {
+			h.logger.Errorf("Not registering commands in guild %v: command /%s is defined both at %s and at %s", event.ID, cmd.name, existing.source, cmd.source)
+			return
+		}
+		cmd.fromPolicy = true
+		pending[cmd.registeredName()] = cmd
+	}
+
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	// Registering the commands replaces all commands of the guild, so commands registered in it in another way, such as
+	// by Handler.Sync, are registered again. Commands of the policy take precedence over them.
+	h.commandsMu.RLock()
+	for name, cmd := range h.registeredIn(event.ID) {
+		if _, ok := pending[name]; !ok && !cmd.fromPolicy {
+			pending[name] = cmd
+		}
+	}
+	h.commandsMu.RUnlock()
+	if err := h.register(discordAPI, event.ID, pending); err != nil {
+		h.logger.Errorf("Failed to register commands after joining guild %v: %v", event.ID, err)
+	}
+}
+
+// handleGuildDelete forgets about all commands registered in the guild of the event if the bot left the guild. Guilds
+// that became unavailable because of an outage are ignored.
+func (h *Handler) handleGuildDelete(event *gateway.GuildDeleteEvent) {
+	if event.Unavailable {
+		return
+	}
+
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+	h.forgetGuild(event.ID)
+	// The commands will have to be registered again if the bot is added back to the guild.
+	h.invalidateRegistration(event.ID)
+	h.logger.Debugf("Forgot commands of guild %v after leaving it", event.ID)
+}
+
+// forgetGuild removes all commands registered in the guild provided from the handler. h.commandsMu must be held while
+// calling forgetGuild.
+func (h *Handler) forgetGuild(guildId discord.GuildID) {
+	for id, cmd := range h.commands {
+		if cmd.guild == guildId {
+			delete(h.commands, id)
+		}
+	}
+}
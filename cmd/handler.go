@@ -1,12 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/gateway"
-	"reflect"
-	"strings"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/diamondburned/arikawa/v3/discord"
 )
@@ -14,11 +15,44 @@ import (
 // Handler contains all commands and handles interactions for these commands. The Handler is suitable for concurrent
 // use.
 type Handler struct {
+	// registerMu is held while registering, synchronising, binding or removing commands, so that these do not overlap
+	// while commandsMu is not held during the API calls.
+	registerMu      sync.Mutex
 	commandsMu      sync.RWMutex
 	commands        map[discord.CommandID]Command
 	pendingCommands map[string]Command
+	// pendingSeq is the sequence number of the last command that became pending.
+	pendingSeq uint64
+	middleware []Middleware
 
-	logger Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	errorHandler  ErrorHandler
+	messages      Messages
+	logger        Logger
+	slowThreshold time.Duration
+	autoDefer     time.Duration
+	policy        DoubleResponsePolicy
+
+	cooldowns      CooldownStore
+	running        concurrencyLimiter
+	maxConcurrency int
+	hashes         HashStore
+
+	devGuild    discord.GuildID
+	devPrefix   string
+	guildPolicy GuildPolicy
+
+	pool       *workerPool
+	busyPolicy BusyPolicy
+
+	// closeMu is held for writing while closing the handler, and for reading while registering a new interaction as in
+	// flight, so that no interactions are started once the handler starts waiting for them.
+	closeMu  sync.RWMutex
+	closed   bool
+	inFlight sync.WaitGroup
+	removers []func()
 }
 
 // NewHandler returns a pointer to a new Handler. This can be used to register & handle commands.
@@ -26,244 +60,1219 @@ func NewHandler(logger Logger) *Handler {
 	if logger == nil {
 		logger = NopLogger{}
 	}
-	return &Handler{
+	h := &Handler{
 		commands:        map[discord.CommandID]Command{},
 		pendingCommands: map[string]Command{},
 
-		logger: logger,
+		messages:      DefaultMessages,
+		logger:        logger,
+		slowThreshold: 2 * time.Second,
+
+		cooldowns: NewMemoryCooldownStore(),
+		running:   concurrencyLimiter{running: map[string]int{}},
 	}
+	h.errorHandler = h.RespondError
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	return h
 }
 
-// WithCommands registers one or multiple commands to the handler.
+// WithCommands registers one or multiple commands to the handler. It panics if a command has the same name as another
+// command provided or a command that is already pending, use Handler.OverrideCommands to replace pending commands
+// intentionally.
 func (h *Handler) WithCommands(commands ...Command) *Handler {
+	if err := h.TryWithCommands(commands...); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// TryWithCommands registers one or multiple commands to the handler in the same way as Handler.WithCommands. Instead of
+// panicking, it returns ValidationErrors naming both definitions of every command name used more than once, in which
+// case none of the commands are registered.
+func (h *Handler) TryWithCommands(commands ...Command) error {
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+
+	var errs ValidationErrors
+	added := map[string]Command{}
+	for _, cmd := range commands {
+		existing, ok := added[cmd.name]
+		if !ok {
+			existing, ok = h.pendingCommands[cmd.name]
+		}
+		if ok {
+			errs = append(errs, ValidationError{Path: "/" + cmd.name, Message: fmt.Sprintf("command is defined both at %s and at %s", existing.source, cmd.source)})
+			continue
+		}
+		added[cmd.name] = cmd
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	for _, cmd := range added {
+		h.setPending(cmd)
+	}
+	return nil
+}
+
+// OverrideCommands registers one or multiple commands to the handler, replacing any pending commands with the same
+// names. If multiple commands provided have the same name, the last one is registered.
+func (h *Handler) OverrideCommands(commands ...Command) *Handler {
 	h.commandsMu.Lock()
 	for _, cmd := range commands {
-		h.pendingCommands[cmd.name] = cmd
+		h.setPending(cmd)
 	}
 	h.commandsMu.Unlock()
 
 	return h
 }
 
+// Use adds middleware to the handler. The middleware will wrap the execution of every command handled by the handler,
+// and run before any middleware added to the commands themselves.
+func (h *Handler) Use(middleware ...Middleware) *Handler {
+	h.commandsMu.Lock()
+	h.middleware = append(h.middleware, middleware...)
+	h.commandsMu.Unlock()
+
+	return h
+}
+
+// WithContext sets the base context of the handler, from which the contexts of all interactions are derived. When it
+// is cancelled, the contexts of all interactions handled are cancelled as well.
+func (h *Handler) WithContext(ctx context.Context) *Handler {
+	h.cancel()
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	return h
+}
+
+// WithErrorHandler sets the function that is called when an ErrorExecutor or middleware returns an error. By default,
+// this is Handler.RespondError.
+func (h *Handler) WithErrorHandler(handler ErrorHandler) *Handler {
+	h.errorHandler = handler
+	return h
+}
+
+// WithMessages sets the messages the handler responds with when it refuses to execute a command. Messages that are left
+// empty or nil are taken from DefaultMessages, except for Restarting.
+func (h *Handler) WithMessages(messages Messages) *Handler {
+	h.messages = messages.withDefaults()
+	return h
+}
+
+// WithSlowThreshold sets how long an executor may take to run before a warning is logged. Executors take 2 seconds by
+// default. A duration of 0 disables the warning.
+func (h *Handler) WithSlowThreshold(d time.Duration) *Handler {
+	h.slowThreshold = d
+	return h
+}
+
+// WithAutoDefer makes the handler defer the response to an interaction if its executor has not responded within the
+// duration provided after the interaction was created. The response is only ephemeral for commands created with
+// Command.WithEphemeralAutoDefer. Calling Interaction.Respond afterwards will edit the deferred response. Discord
+// requires a response within 3 seconds, so a duration of around 2.5 seconds is recommended. A duration of 0, the
+// default, disables automatic deferral.
+func (h *Handler) WithAutoDefer(d time.Duration) *Handler {
+	h.autoDefer = d
+	return h
+}
+
+// WithDoubleResponsePolicy sets what happens when an executor responds to an interaction that has already been responded
+// to. By default, DoubleResponseError is used.
+func (h *Handler) WithDoubleResponsePolicy(policy DoubleResponsePolicy) *Handler {
+	h.policy = policy
+	return h
+}
+
+// WithCooldownStore sets the CooldownStore used to keep track of the cooldowns of commands. By default, a
+// MemoryCooldownStore is used.
+func (h *Handler) WithCooldownStore(store CooldownStore) *Handler {
+	h.cooldowns = store
+	return h
+}
+
+// WithMaxConcurrency sets the maximum amount of commands the handler executes at the same time, across all commands.
+// Commands executed while the limit is reached are responded to with Messages.ConcurrencyLimit. By default, there is no
+// limit.
+func (h *Handler) WithMaxConcurrency(n int) *Handler {
+	if n < 0 {
+		panic("max concurrency must not be negative")
+	}
+	h.maxConcurrency = n
+	return h
+}
+
+// WithHashStore sets the HashStore used to remember which commands were last registered in every scope. When the
+// commands pending in a scope have not changed since they were last registered, Handler.RegisterAll and
+// Handler.RegisterAllGuild bind them to the IDs stored instead of registering them again. By default, no HashStore is
+// used and commands are always registered.
+func (h *Handler) WithHashStore(store HashStore) *Handler {
+	h.hashes = store
+	return h
+}
+
+// WithDevGuild enables development mode. In development mode, all commands are registered in the guild provided instead
+// of in the scope they would normally be registered in, so that changes show up instantly. The prefix provided is put
+// in front of the names of the commands, so that they can be told apart from the production commands, which are left
+// untouched. Passing an invalid guild ID disables development mode.
+func (h *Handler) WithDevGuild(guildId discord.GuildID, prefix string) *Handler {
+	h.commandsMu.Lock()
+	h.devGuild, h.devPrefix = guildId, prefix
+	h.commandsMu.Unlock()
+
+	return h
+}
+
+// WithGuildPolicy makes the handler register the commands returned by the GuildPolicy provided in every guild the bot
+// joins, once the handler is listening. When the bot leaves a guild, the commands registered in it are forgotten. As
+// guilds become available every time the bot connects to the gateway, using a HashStore is recommended to prevent
+// registering the same commands over and over.
+func (h *Handler) WithGuildPolicy(policy GuildPolicy) *Handler {
+	h.guildPolicy = policy
+	return h
+}
+
+// WithWorkers makes the handler execute commands on a fixed amount of workers, instead of on the goroutine of the gateway
+// event handler. Commands wait in a queue of the size provided until a worker is available. When the queue is full, the
+// BusyPolicy decides what happens. WithWorkers may only be called once, before calling Handler.Listen.
+func (h *Handler) WithWorkers(workers, queueSize int, policy BusyPolicy) *Handler {
+	if workers <= 0 || queueSize < 0 {
+		panic("the amount of workers must be positive and the queue size must not be negative")
+	}
+	h.closeMu.RLock()
+	listening := len(h.removers) > 0
+	h.closeMu.RUnlock()
+	// Replacing a pool would drop the commands in its queue, and the pool is read without locking while listening.
+	if h.pool != nil || listening {
+		panic("the workers of a handler can only be set once, before it starts listening")
+	}
+	h.pool = newWorkerPool(workers, queueSize)
+	h.busyPolicy = policy
+	return h
+}
+
+// PoolStats returns statistics about the workers of the handler. If the handler does not use workers, false is
+// returned.
+func (h *Handler) PoolStats() (PoolStats, bool) {
+	if h.pool == nil {
+		return PoolStats{}, false
+	}
+	return h.pool.stats(), true
+}
+
 // RegisterAll will globally register all currently unregistered commands. When commands are modified, this can take up
 // to an hour to update in guilds. Doing this will remove all other global commands not currently pending in this
-// handler.
+// handler. The guilds set using Command.WithGuilds are ignored, use Handler.Sync to register commands in their own
+// scope.
 func (h *Handler) RegisterAll(api API) error {
 	return h.RegisterAllGuild(api, discord.NullGuildID)
 }
 
 // RegisterAllGuild will register all currently pending commands in a specific guild. Commands will be updated instantly
-// in the guild in question. This will however remove all other guild commands not registered in this batch.
+// in the guild in question. This will however remove all other guild commands not registered in this batch. If the
+// handler has a HashStore and the pending commands have not changed since they were last registered in the guild, no
+// API calls are made and the commands are bound to the IDs they were registered with. In development mode, the
+// commands are registered in the development guild instead.
 func (h *Handler) RegisterAllGuild(discordAPI API, guildId discord.GuildID) error {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	h.commandsMu.RLock()
+	guildId = h.target(guildId)
+	pending := h.pending()
+	h.commandsMu.RUnlock()
+
 	// Skip registering commands if there are no commands to register
-	if len(h.pendingCommands) == 0 {
+	if len(pending) == 0 {
 		return nil
 	}
+	if err := h.register(discordAPI, guildId, pending); err != nil {
+		return err
+	}
+
+	// Commands replaced while registering stay pending, as they were not registered.
+	h.commandsMu.Lock()
+	h.removePending(pending)
+	h.commandsMu.Unlock()
+	return nil
+}
+
+// register registers the commands provided, by the name they are registered with, in the guild provided, replacing all
+// other commands in the guild. If the handler has a HashStore and the commands have not changed since they were last
+// registered, they are bound to the IDs they were registered with instead. h.commandsMu is only held while binding the
+// commands, so that interactions can still be handled while waiting for discord. h.registerMu must be held while
+// calling register.
+func (h *Handler) register(discordAPI API, guildId discord.GuildID, pending map[string]Command) error {
+	if err := validateCommands(guildId, pending); err != nil {
+		return err
+	}
+
+	var cmds []commandData
+	for _, cmd := range pending {
+		cmds = append(cmds, cmd.marshal())
+	}
+
+	scope := scopeName(guildId)
+	hash, err := hashCommandData(cmds)
+	if err != nil {
+		return err
+	}
+	if h.hashes != nil {
+		if reg, ok, err := h.hashes.Registration(guildId); err != nil {
+			h.logger.Warnf("Failed to load registration of commands %s: %v", scope, err)
+		} else if ok && reg.Hash == hash {
+			h.commandsMu.Lock()
+			bound := h.bindRegistration(guildId, reg, pending)
+			h.commandsMu.Unlock()
+			if bound {
+				h.logger.Printf("Skipped registering %d unchanged commands %s", len(cmds), scope)
+				return nil
+			}
+		}
+	}
+
 	app, err := discordAPI.CurrentApplication()
 	if err != nil {
 		return err
 	}
+	registeredCommands, err := bulkOverwriteCommands(discordAPI, app.ID, guildId, cmds)
+	if err != nil {
+		h.logger.Errorf("Failed to register %d commands %s: %v", len(cmds), scope, err)
+		return err
+	}
+	h.logger.Printf("Registered %d commands %s", len(registeredCommands), scope)
 
-	var cmds []api.CreateCommandData
+	reg := Registration{Hash: hash, Commands: map[string]discord.CommandID{}}
+	h.commandsMu.Lock()
+	// All other commands in the guild were removed by registering these, so they should no longer be handled.
+	h.forgetGuild(guildId)
+	for _, registeredCmd := range registeredCommands {
+		cmd := pending[registeredCmd.Name]
+		cmd.guild = guildId
+		cmd.registered = true
+
+		h.commands[registeredCmd.ID] = cmd
+		reg.Commands[registeredCmd.Name] = registeredCmd.ID
+	}
+	h.commandsMu.Unlock()
+
+	if h.hashes != nil {
+		if err = h.hashes.SetRegistration(guildId, reg); err != nil {
+			// The commands were registered, so only the next registration is affected.
+			h.logger.Warnf("Failed to store registration of commands %s: %v", scope, err)
+		}
+	}
+	return nil
+}
+
+// bindRegistration binds the pending commands provided, by the name they are registered with, to the IDs of the
+// Registration provided. If the Registration does not contain an ID for every pending command, nothing is bound and
+// false is returned. h.commandsMu must be held while calling bindRegistration.
+func (h *Handler) bindRegistration(guildId discord.GuildID, reg Registration, pending map[string]Command) bool {
+	for name := range pending {
+		if _, ok := reg.Commands[name]; !ok {
+			return false
+		}
+	}
+	h.forgetGuild(guildId)
+	for name, cmd := range pending {
+		cmd.guild = guildId
+		cmd.registered = true
+
+		h.commands[reg.Commands[name]] = cmd
+	}
+	return true
+}
+
+// SyncAll synchronises the global commands registered to discord with the pending and registered global commands of
+// the handler. Unlike RegisterAll, only the commands that were added, changed or removed are sent to discord. The plan
+// of the changes is returned, and is not applied if SyncOptions.DryRun is set.
+func (h *Handler) SyncAll(api API, opts SyncOptions) (SyncPlan, error) {
+	return h.SyncAllGuild(api, discord.NullGuildID, opts)
+}
+
+// SyncAllGuild synchronises the commands registered to discord in a specific guild with the pending commands and the
+// commands registered in the guild by the handler. Commands registered to discord that the handler does not have are
+// deleted, unless SyncOptions.KeepUnknown is set. In development mode, the commands in the development guild are
+// synchronised instead.
+func (h *Handler) SyncAllGuild(discordAPI API, guildId discord.GuildID, opts SyncOptions) (SyncPlan, error) {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	h.commandsMu.RLock()
+	guildId = h.target(guildId)
+	local := h.registeredIn(guildId)
+	pending := h.pending()
+	h.commandsMu.RUnlock()
+	for name, cmd := range pending {
+		local[name] = cmd
+	}
+
+	if err := validateCommands(guildId, local); err != nil {
+		return SyncPlan{}, err
+	}
+	app, err := discordAPI.CurrentApplication()
+	if err != nil {
+		return SyncPlan{}, err
+	}
+	plan, result, err := h.syncScope(discordAPI, app.ID, guildId, local, opts)
 
 	h.commandsMu.Lock()
 	defer h.commandsMu.Unlock()
+	h.bindSync(result)
+	if err == nil && !opts.DryRun {
+		h.removePending(pending)
+	}
+	return plan, err
+}
+
+// Sync synchronises the commands registered to discord with the commands of the handler in every scope at once. Unlike
+// the other methods registering commands, pending commands are registered in the guilds set using Command.WithGuilds,
+// or globally if they have none. The global commands are always synchronised, together with the commands of every guild
+// that a pending command is registered in or that the handler registered commands in before. The plans of all scopes
+// are returned, with the global scope first. In development mode, only the commands in the development guild are
+// synchronised, which are all commands of the handler.
+func (h *Handler) Sync(discordAPI API, opts SyncOptions) ([]SyncPlan, error) {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	// The commands of every scope are determined up front, so that h.commandsMu does not have to be held while waiting
+	// for discord.
+	h.commandsMu.RLock()
+	err := h.validate()
+	scopes := h.scopes()
+	local := make([]map[string]Command, len(scopes))
+	for i, guildId := range scopes {
+		local[i] = h.scopeCommands(guildId)
+	}
+	pending := h.pending()
+	h.commandsMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	app, err := discordAPI.CurrentApplication()
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []SyncPlan
+	for i, guildId := range scopes {
+		plan, result, err := h.syncScope(discordAPI, app.ID, guildId, local[i], opts)
+		h.commandsMu.Lock()
+		h.bindSync(result)
+		h.commandsMu.Unlock()
+		plans = append(plans, plan)
+		if err != nil {
+			// Commands that were already synchronised stay pending, but will be unchanged when synchronising again.
+			return plans, err
+		}
+	}
+	if !opts.DryRun {
+		h.commandsMu.Lock()
+		h.removePending(pending)
+		h.commandsMu.Unlock()
+	}
+	return plans, nil
+}
+
+// Validate validates all commands of the handler in the scopes they are registered in by Handler.Sync, and returns
+// ValidationErrors containing every problem found. If all commands are valid, nil is returned. The commands are also
+// validated by all methods registering them, before any API call is made.
+func (h *Handler) Validate() error {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+	return h.validate()
+}
+
+// validate validates all commands of the handler in the scopes they are registered in by Handler.Sync. h.commandsMu
+// must be held while calling validate.
+func (h *Handler) validate() error {
+	var errs ValidationErrors
+	for _, guildId := range h.scopes() {
+		if err := validateCommands(guildId, h.scopeCommands(guildId)); err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		}
+	}
+	return errs.err()
+}
+
+// scopeCommands returns the commands that Handler.Sync registers in the guild provided, or globally if
+// discord.NullGuildID is provided, by the name they are registered with. h.commandsMu must be held while calling
+// scopeCommands.
+func (h *Handler) scopeCommands(guildId discord.GuildID) map[string]Command {
+	local := h.registeredIn(guildId)
+	for name, cmd := range h.pending() {
+		// Pending commands replace registered commands with the same name, even if they are no longer registered in
+		// this scope.
+		if h.devGuild.IsValid() || cmd.inScope(guildId) {
+			local[name] = cmd
+		} else {
+			delete(local, name)
+		}
+	}
+	return local
+}
+
+// scopes returns the scopes that Handler.Sync synchronises: The global scope, followed by all guilds that pending
+// commands are registered in and that commands were registered in before, sorted by ID. In development mode, only the
+// development guild is returned. h.commandsMu must be held while calling scopes.
+func (h *Handler) scopes() []discord.GuildID {
+	if h.devGuild.IsValid() {
+		return []discord.GuildID{h.devGuild}
+	}
+	seen := map[discord.GuildID]struct{}{}
+	var guilds []discord.GuildID
+	add := func(guildId discord.GuildID) {
+		if _, ok := seen[guildId]; !ok && guildId.IsValid() {
+			seen[guildId] = struct{}{}
+			guilds = append(guilds, guildId)
+		}
+	}
 	for _, cmd := range h.pendingCommands {
-		cmds = append(cmds, cmd.marshal())
+		for _, guildId := range cmd.scope {
+			add(guildId)
+		}
+	}
+	for _, cmd := range h.commands {
+		add(cmd.guild)
 	}
+	sort.Slice(guilds, func(i, j int) bool {
+		return guilds[i] < guilds[j]
+	})
+	return append([]discord.GuildID{discord.NullGuildID}, guilds...)
+}
 
-	var registeredCommands []discord.Command
-	if guildId == discord.NullGuildID {
-		registeredCommands, err = discordAPI.BulkOverwriteCommands(app.ID, cmds)
-	} else {
-		registeredCommands, err = discordAPI.BulkOverwriteGuildCommands(app.ID, guildId, cmds)
+// registeredIn returns the commands registered in the guild provided by the handler, or the global commands if
+// discord.NullGuildID is provided, by the name they are registered with. h.commandsMu must be held while calling
+// registeredIn.
+func (h *Handler) registeredIn(guildId discord.GuildID) map[string]Command {
+	local := map[string]Command{}
+	for _, cmd := range h.commands {
+		if cmd.guild == guildId {
+			local[cmd.registeredName()] = cmd
+		}
+	}
+	return local
+}
+
+// pending returns the pending commands by the name they are registered with. In development mode, the prefix of the
+// development guild is applied to them. h.commandsMu must be held while calling pending.
+func (h *Handler) pending() map[string]Command {
+	pending := make(map[string]Command, len(h.pendingCommands))
+	for _, cmd := range h.pendingCommands {
+		if h.devGuild.IsValid() {
+			cmd.prefix = h.devPrefix
+		}
+		pending[cmd.registeredName()] = cmd
 	}
+	return pending
+}
+
+// setPending makes the command provided pending, replacing any pending command with the same name. Every command that
+// becomes pending gets a new sequence number, so that it can be told apart from the command it replaced.
+// h.commandsMu must be held while calling setPending.
+func (h *Handler) setPending(cmd Command) {
+	h.pendingSeq++
+	cmd.pendingSeq = h.pendingSeq
+	h.pendingCommands[cmd.name] = cmd
+}
+
+// removePending removes the pending commands provided once they have been registered. Commands that were replaced in
+// the meantime stay pending. h.commandsMu must be held while calling removePending.
+func (h *Handler) removePending(commands map[string]Command) {
+	for _, cmd := range commands {
+		if current, ok := h.pendingCommands[cmd.name]; ok && current.pendingSeq == cmd.pendingSeq {
+			delete(h.pendingCommands, cmd.name)
+		}
+	}
+}
+
+// target returns the guild in which commands are registered when registering them in the guild provided, which is the
+// development guild in development mode. h.commandsMu must be held while calling target.
+func (h *Handler) target(guildId discord.GuildID) discord.GuildID {
+	if h.devGuild.IsValid() {
+		return h.devGuild
+	}
+	return guildId
+}
+
+// syncResult contains the changes made to the commands registered to discord in a single scope by applying a SyncPlan,
+// which still have to be made to the commands of the handler.
+type syncResult struct {
+	guildId discord.GuildID
+	// bound contains the commands of the handler by the IDs they are registered with.
+	bound map[discord.CommandID]Command
+	// deleted contains the IDs of the commands deleted from discord.
+	deleted []discord.CommandID
+}
+
+// syncScope synchronises the commands registered to discord in the scope provided with the local commands provided. The
+// changes made to discord are returned, even if an error occurred while making them. h.registerMu must be held while
+// calling syncScope, but h.commandsMu does not have to be, so that interactions can still be handled while waiting for
+// discord.
+func (h *Handler) syncScope(discordAPI API, appId discord.AppID, guildId discord.GuildID, local map[string]Command, opts SyncOptions) (SyncPlan, syncResult, error) {
+	result := syncResult{guildId: guildId, bound: map[discord.CommandID]Command{}}
+	remote, raw, err := fetchRemoteCommands(discordAPI, appId, guildId)
+	if err != nil {
+		return SyncPlan{}, result, err
+	}
+	plan, err := planSync(guildId, local, remote, opts, raw)
+	if err != nil || opts.DryRun {
+		return plan, result, err
+	}
+	if err = h.applySync(discordAPI, appId, plan, &result); err != nil {
+		h.logger.Errorf("Failed to synchronise commands %s: %v", scopeName(guildId), err)
+		return plan, result, err
+	}
+	h.logger.Printf("Synchronised commands %s: %d created, %d updated, %d deleted", scopeName(guildId), len(plan.Create), len(plan.Update), len(plan.Delete))
+	return plan, result, nil
+}
+
+// applySync applies the changes in the SyncPlan provided, and adds every change made to the syncResult provided.
+// h.registerMu must be held while calling applySync.
+func (h *Handler) applySync(discordAPI API, appId discord.AppID, plan SyncPlan, result *syncResult) error {
+	if !plan.Empty() {
+		// The commands registered no longer match the Registration stored, which would otherwise be used by the next
+		// call to RegisterAll or RegisterAllGuild to skip registering commands.
+		h.invalidateRegistration(plan.Guild)
+	}
+	for _, id := range plan.delete {
+		if err := deleteCommand(discordAPI, appId, plan.Guild, id); err != nil {
+			return err
+		}
+		result.deleted = append(result.deleted, id)
+	}
+	for id, cmd := range plan.update {
+		if _, err := editCommand(discordAPI, appId, plan.Guild, id, cmd.marshal()); err != nil {
+			return err
+		}
+		result.bound[id] = cmd
+	}
+	for _, cmd := range plan.create {
+		registered, err := createCommand(discordAPI, appId, plan.Guild, cmd.marshal())
+		if err != nil {
+			return err
+		}
+		result.bound[registered.ID] = cmd
+	}
+	for id, cmd := range plan.same {
+		result.bound[id] = cmd
+	}
+	return nil
+}
+
+// bindSync makes the changes in the syncResult provided to the commands of the handler. h.commandsMu must be held while
+// calling bindSync.
+func (h *Handler) bindSync(result syncResult) {
+	for _, id := range result.deleted {
+		delete(h.commands, id)
+	}
+	for id, cmd := range result.bound {
+		cmd.guild = result.guildId
+		cmd.registered = true
+
+		h.commands[id] = cmd
+	}
+}
+
+// Bind binds the global commands already registered to discord, for example by another process, to the pending commands
+// of the handler with the same names. Unlike RegisterAll, this does not change any commands registered to discord.
+// Commands that are bound are no longer pending.
+func (h *Handler) Bind(api API) error {
+	return h.BindGuild(api, discord.NullGuildID)
+}
+
+// BindGuild binds the commands already registered to discord in a specific guild to the commands of the handler with the
+// same names. Pending commands are bound first, but commands that were already registered or bound in another scope can
+// be bound again. Unlike RegisterAllGuild, this does not change any commands registered to discord. In development mode,
+// the commands in the development guild are bound instead.
+func (h *Handler) BindGuild(discordAPI API, guildId discord.GuildID) error {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+
+	app, err := discordAPI.CurrentApplication()
 	if err != nil {
 		return err
 	}
+	h.commandsMu.RLock()
+	guildId = h.target(guildId)
+	h.commandsMu.RUnlock()
 
-	for _, registeredCmd := range registeredCommands {
-		cmd := h.pendingCommands[registeredCmd.Name]
+	remote, err := fetchCommands(discordAPI, app.ID, guildId)
+	if err != nil {
+		return err
+	}
+
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+
+	pending := h.pending()
+	var bound int
+	for _, remoteCmd := range remote {
+		if remoteCmd.Type != discord.ChatInputCommand {
+			continue
+		}
+		cmd, ok := pending[remoteCmd.Name]
+		if ok {
+			delete(h.pendingCommands, cmd.name)
+		} else if cmd, ok = h.commandByName(remoteCmd.Name, guildId); !ok {
+			h.logger.Debugf("Not binding unknown command /%s (%v)", remoteCmd.Name, remoteCmd.ID)
+			continue
+		}
 		cmd.guild = guildId
 		cmd.registered = true
 
-		h.commands[registeredCmd.ID] = cmd
+		h.commands[remoteCmd.ID] = cmd
+		bound++
 	}
-	h.pendingCommands = map[string]Command{}
+	h.logger.Printf("Bound %d of %d registered commands %s", bound, len(remote), scopeName(guildId))
 	return nil
 }
 
-// Listen registers a listen function.
+// Listen registers a listen function. If the handler has a GuildPolicy, it also listens for guilds being joined and left.
 func (h *Handler) Listen(api API) error {
 	app, err := api.CurrentApplication()
 	if err != nil {
 		return err
 	}
 	appId := app.ID
+	owners := applicationOwners(app)
+
+	h.closeMu.Lock()
+	defer h.closeMu.Unlock()
+	if h.closed {
+		return ErrHandlerClosed
+	}
+	h.removers = append(h.removers, api.AddHandler(func(event *gateway.InteractionCreateEvent) {
+		h.handleInteraction(api, appId, owners, event)
+	}))
+	if h.guildPolicy != nil {
+		h.removers = append(h.removers, api.AddHandler(func(event *gateway.GuildCreateEvent) {
+			// Every guild becomes available when connecting to the gateway, so registering commands in them one after
+			// another would hold up all other events for a long time.
+			go h.handleGuildCreate(api, event)
+		}), api.AddHandler(h.handleGuildDelete))
+	}
+	return nil
+}
+
+// Close shuts the handler down. New interactions are no longer handled, and are responded to with Messages.Restarting
+// if it is not empty. The contexts of all interactions being handled are cancelled, after which Close waits for their
+// executors to return until the context provided is done. Finally, the handler is removed from all APIs it was
+// listening to, and its CooldownStore is flushed if it has a Flush method. Commands executed by goroutines the
+// executors started themselves are not waited for.
+//
+// Close would wait for the executor calling it to return, so it returns ErrCloseInExecutor without closing the handler
+// if the context provided is that of an interaction handled by it. Executors should call Close on a new goroutine
+// instead, for example using go h.Close(context.Background()).
+func (h *Handler) Close(ctx context.Context) error {
+	if ctx.Value(handlerKey{}) == h {
+		return ErrCloseInExecutor
+	}
+	h.closeMu.Lock()
+	if h.closed {
+		h.closeMu.Unlock()
+		return ErrHandlerClosed
+	}
+	h.closed = true
+	h.closeMu.Unlock()
+
+	h.logger.Printf("Shutting down command handler")
+	h.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		h.logger.Warnf("Stopped waiting for commands to finish executing: %v", err)
+	}
+
+	for _, rm := range h.removers {
+		rm()
+	}
+	if h.pool != nil {
+		h.pool.stop()
+	}
+	// Stores that write cooldowns in the background, such as FileCooldownStore, are flushed so no cooldowns are lost.
+	if store, ok := h.cooldowns.(interface{ Flush() error }); ok {
+		if flushErr := store.Flush(); flushErr != nil {
+			h.logger.Errorf("Failed to save cooldowns: %v", flushErr)
+		}
+	}
+	return err
+}
+
+// handleInteraction handles an interaction received from the gateway. Only command interactions for commands known to
+// the handler are handled.
+func (h *Handler) handleInteraction(api API, appId discord.AppID, owners map[discord.UserID]struct{}, event *gateway.InteractionCreateEvent) {
+	// A malformed interaction should never be able to take down the process, as this is ran in the event handler
+	// of the gateway.
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Errorf("Recovered from panic while handling interaction %v: %v\n%s", event.ID, r, debug.Stack())
+		}
+	}()
+
+	commandEvent, ok := event.Data.(*discord.CommandInteraction)
+	if !ok {
+		// Only handle command interactions
+		return
+	}
+
+	// Register the interaction as in flight, so that Close waits for it. Once the handler is closing, no new
+	// interactions are handled.
+	h.closeMu.RLock()
+	if h.closed {
+		h.closeMu.RUnlock()
+		if h.messages.Restarting != "" {
+			err := api.RespondInteraction(event.ID, event.Token, MessageResponse{Content: h.messages.Restarting, Ephemeral: true}.marshal())
+			if err != nil {
+				h.logger.Errorf("Failed to respond to interaction %v: %v", event.ID, err)
+			}
+		}
+		return
+	}
+	h.inFlight.Add(1)
+	h.closeMu.RUnlock()
 
-	handler := func(event *gateway.InteractionCreateEvent) {
-		commandEvent, ok := event.Data.(*discord.CommandInteraction)
+	// Once the command is handed to the task executing it, the task marks the interaction as no longer in flight.
+	// Until then, it needs to be done when returning.
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			h.inFlight.Done()
+		}
+	}()
+
+	// Command fetching
+	// ----------------
+	// This section handles the looking for the correct command to execute, and also the right command executor.
+	var command Command
+	var executor any
+	var options discord.CommandInteractionOptions
+	var path string
+	var middleware [][]Middleware
+	var cooldowns []namedCooldown
+	{
+		// Get the command with the correct id
+		command, ok = h.lookup(commandEvent.ID, commandEvent.Name, event.GuildID)
+		h.commandsMu.RLock()
+		middleware = [][]Middleware{h.middleware, command.middleware}
+		h.commandsMu.RUnlock()
 		if !ok {
-			// Only handle command interactions
+			h.logger.Debugf("Dropped interaction %v for unknown command /%s (%v)", event.ID, commandEvent.Name, commandEvent.ID)
 			return
 		}
+		path = command.name
+		if command.cooldown != nil {
+			cooldowns = append(cooldowns, namedCooldown{name: command.name, Cooldown: *command.cooldown})
+		}
 
-		// Command fetching
-		// ----------------
-		// This section handles the looking for the correct command to execute, and also the right command executor.
-		var executor Executor
-		var options discord.CommandInteractionOptions
-		{
-			// Get the command with the correct id
-			h.commandsMu.RLock()
-			command, ok := h.commands[commandEvent.ID]
-			h.commandsMu.RUnlock()
-			if !ok {
+		// Get the right executor for the command. A command can either only have a main executor, or only
+		// subcommand executors. Also get the correct command options.
+		options = commandEvent.Options
+		if command.executor == nil {
+			// Get the first parameter. This will be the subcommand name or, if applicable, the subcommand group it
+			// is in.
+			if len(options) == 0 {
+				h.logger.Warnf("Dropped interaction %v for /%s: no subcommand provided", event.ID, path)
 				return
 			}
+			subOpt := options[0]
+			subName := subOpt.Name
+			options = subOpt.Options
 
-			// Get the right executor for the command. A command can either only have a main executor, or only
-			// subcommand executors. Also get the correct command options.
-			options = commandEvent.Options
-			if command.executor == nil {
-				// Get the first parameter. This will be the subcommand name or, if applicable, the subcommand group it
-				// is in.
-				subOpt := options[0]
-				subName := subOpt.Name
-				options = subOpt.Options
-
-				// If a subcommand group with this name exists, get the full subcommand name
-				// ("subcommandGroup subcommand")
-				if _, ok = command.subGroups[subName]; ok {
-					subOpt2 := options[0]
-					options = subOpt2.Options
-
-					subName += " " + subOpt2.Name
-				}
-
-				subCmd, ok := command.subcommands[subName]
-				if !ok {
+			// If a subcommand group with this name exists, get the full subcommand name
+			// ("subcommandGroup subcommand")
+			if _, ok = command.subGroups[subName]; ok {
+				if len(options) == 0 {
+					h.logger.Warnf("Dropped interaction %v for /%s %s: no subcommand provided", event.ID, path, subName)
 					return
 				}
-				executor = subCmd.executor
-			} else {
-				executor = command.executor
+				middleware = append(middleware, command.groupMiddleware[subName])
+
+				subOpt2 := options[0]
+				options = subOpt2.Options
+
+				subName += " " + subOpt2.Name
+			}
+
+			subCmd, ok := command.subcommands[subName]
+			if !ok {
+				h.logger.Warnf("Dropped interaction %v for /%s: unknown subcommand %s", event.ID, path, subName)
+				return
+			}
+			executor = subCmd.executor
+			path += " " + subName
+			if subCmd.cooldown != nil {
+				cooldowns = append(cooldowns, namedCooldown{name: path, Cooldown: *subCmd.cooldown})
 			}
+		} else {
+			executor = command.executor
 		}
+	}
 
-		// Command parameterization
-		// ------------------------
-		// In this section, a new instance of the right executor will be created and all parameters will be set.
-		{
-			refl := reflect.New(reflect.TypeOf(executor)).Elem()
-			for _, option := range options {
-				field := refl.FieldByNameFunc(func(s string) bool {
-					return option.Name == strings.ToLower(s)
-				})
-				if !field.IsValid() {
-					panic(fmt.Sprintf("Field %s not valid", field.String()))
-				}
+	// The cmd.Interaction contains extra parameters such as the sender and allows for the executor to send responses
+	// back to discord.
+	interaction := &Interaction{
+		api:   api,
+		appId: appId,
+
+		interactionId:    event.ID,
+		interactionToken: event.Token,
+
+		guildId:   event.GuildID,
+		channelId: event.ChannelID,
+		member:    event.Member,
+		user:      event.Sender(),
+
+		policy: h.policy,
+	}
+	interaction.logger = newPrefixLogger(h.logger, interactionPrefix(path, interaction))
+
+	// Discord's clock may be ahead of ours, in which case the interaction should not seem to be created in the
+	// future.
+	created := event.ID.Time()
+	if now := time.Now(); created.After(now) {
+		created = now
+	}
+	interaction.ctx, interaction.cancel = newInteractionContext(h.ctx, created)
+	interaction.ctx = context.WithValue(interaction.ctx, handlerKey{}, h)
+	interaction.expires = created.Add(tokenLifetime)
+
+	// Command checks
+	// --------------
+	// This section makes sure the user is allowed to execute the command in the place they executed it in. The default
+	// member permissions of the command are not checked, as discord already enforces them together with the overrides
+	// guild admins set for them.
+	if msg, ok := h.checkCommand(api, command, executor, interaction, owners); !ok {
+		h.refuse(interaction, msg)
+		return
+	}
+
+	// Command parameterization
+	// ------------------------
+	// In this section, a new instance of the right executor will be created and all parameters will be set.
+	execution := Execution{
+		Path:        path,
+		Command:     command,
+		Executor:    executor,
+		Interaction: interaction,
+	}
+	executor, err := parameterize(executor, options)
+	if err != nil {
+		defer interaction.cancel()
+		h.errorHandler(execution, err)
+		return
+	}
+	execution.Executor = executor
 
-				// Determine what to cast the command option to depending on the parameter type
-				field.Set(reflect.ValueOf(func() interface{} {
-					instance := field.Interface()
-
-					actualType := instance
-					if opt, ok := instance.(optional); ok {
-						actualType = opt.get()
-					}
-
-					var r interface{}
-					var err error
-
-					switch actualType.(type) {
-					case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-						var temp int64
-						temp, err = option.IntValue()
-						// Determine which type of int the type actually is, and cast it
-						switch actualType.(type) {
-						case int:
-							r = int(temp)
-						case uint:
-							r = uint(temp)
-						case int8:
-							r = int8(temp)
-						case uint8:
-							r = uint8(temp)
-						case int16:
-							r = int16(temp)
-						case uint16:
-							r = uint16(temp)
-						case int32:
-							r = int32(temp)
-						case uint32:
-							r = uint32(temp)
-						case int64:
-							r = int64(temp)
-						case uint64:
-							r = uint64(temp)
-						}
-					case float32, float64:
-						var temp float64
-						temp, err = option.FloatValue()
-						if _, ok := actualType.(float32); ok {
-							r = float32(temp)
-						} else {
-							r = temp
-						}
-					case string:
-						r = option.String()
-					case bool:
-						r, err = option.BoolValue()
-
-					case User, Role, Channel, Mentionable:
-						var temp discord.Snowflake
-						temp, err = option.SnowflakeValue()
-						switch actualType.(type) {
-						case User:
-							r = User(temp)
-						case Role:
-							r = Role(temp)
-						case Channel:
-							r = Channel(temp)
-						case Mentionable:
-							r = Mentionable(temp)
-						}
-
-					default:
-						panic(fmt.Sprintf("Unrecognized parameter type: %s", field.Type()))
-					}
-
-					if err != nil {
-						panic(err)
-					}
-					if opt, ok := instance.(optional); ok {
-						r = opt.set(r)
-					}
-					return r
-				}()))
+	// Command execution
+	// -----------------
+	// This section executes the command, either directly or on one of the workers of the handler.
+	if h.autoDefer > 0 {
+		// The timer is not stopped when the executor returns, as it may still respond from another goroutine.
+		time.AfterFunc(time.Until(created.Add(h.autoDefer)), func() {
+			if err := interaction.autoDefer(command.ephemeralDefer); err != nil {
+				interaction.logger.Errorf("Failed to automatically defer response: %v", err)
+			}
+		})
+	}
+	task := func(wait time.Duration) {
+		defer h.inFlight.Done()
+		// Tasks may run on a worker, where a panic in the error handler would otherwise take down the process.
+		defer func() {
+			if r := recover(); r != nil {
+				interaction.logger.Errorf("Recovered from panic while executing command: %v\n%s", r, debug.Stack())
 			}
-			// Set the actual executor
-			executor = refl.Interface().(Executor)
+		}()
+		if err := interaction.ctx.Err(); err != nil {
+			interaction.logger.Warnf("Dropped interaction while waiting for a worker: %v", err)
+			return
 		}
 
-		// Command execution
-		// -----------------
-		// This section executes the command and creates the cmd.Interaction, which contains extra parameters such as
-		// the sender and allows for the executor to send responses back to discord.
-		executor.Run(&Interaction{
-			api:   api,
-			appId: appId,
+		// Cooldowns are only taken once the command is about to be executed, so that an interaction that is
+		// rejected or dropped because the handler is busy does not use up a use of a cooldown.
+		releaseCooldowns, msg, ok := h.takeCooldowns(interaction, cooldowns)
+		if !ok {
+			h.refuse(interaction, msg)
+			return
+		}
+		defer releaseCooldowns()
 
-			interactionId:    event.ID,
-			interactionToken: event.Token,
+		execution.QueueWait = wait
+		h.execute(execution, middleware)
+	}
+	handedOff = true
+	if h.pool == nil {
+		task(0)
+		return
+	}
+	if h.pool.trySubmit(task) {
+		return
+	}
+	if h.busyPolicy == BusyReject {
+		h.inFlight.Done()
+		h.refuse(interaction, h.messages.Busy)
+		return
+	}
+	// Waiting for room in the queue would block the gateway event handler, so it is done on a separate goroutine. The
+	// interaction stays in flight until it is released, so Close waits for it.
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				h.inFlight.Done()
+				interaction.logger.Errorf("Recovered from panic while waiting for a worker: %v\n%s", r, debug.Stack())
+			}
+		}()
+		if err := interaction.autoDefer(command.ephemeralDefer); err != nil {
+			interaction.logger.Errorf("Failed to automatically defer response: %v", err)
+		}
+		if err := h.pool.submit(interaction.ctx, task); err != nil {
+			h.inFlight.Done()
+			interaction.logger.Warnf("Dropped interaction while waiting for a worker: %v", err)
+		}
+	}()
+}
 
-			guildId:   event.GuildID,
-			channelId: event.ChannelID,
-			member:    event.Member,
-			user:      event.Sender(),
-		})
+// execute executes the command of the Execution provided, wrapped in all middleware provided. Panics in the executor or
+// middleware are recovered and passed to the error handler as a PanicError.
+func (h *Handler) execute(e Execution, middleware [][]Middleware) {
+	start := time.Now()
+	err := execute(chain(run, middleware...), e)
+	if d := time.Since(start); h.slowThreshold > 0 && d > h.slowThreshold {
+		e.Interaction.logger.Warnf("Executor took %v to run", d)
 	}
-	api.AddHandler(handler)
-	return nil
+	if err != nil {
+		h.errorHandler(e, err)
+	}
+}
+
+// lookup returns the command with the ID provided. If the handler does not know the ID, for example because the command
+// was registered by another process, the command is looked up by its name and the guild the interaction was created
+// in instead. The ID is not bound to commands found this way, as the scope they were registered in is not known: Use
+// Handler.Bind or Handler.BindGuild for that. Interactions do not contain the type of the command, but only chat
+// commands can be created by the handler.
+func (h *Handler) lookup(id discord.CommandID, name string, guildId discord.GuildID) (Command, bool) {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+
+	if command, ok := h.commands[id]; ok {
+		return command, true
+	}
+	if command, ok := h.commandByName(name, guildId); ok {
+		h.logger.Debugf("Resolved unknown command ID %v to registered command /%s", id, name)
+		return command, true
+	}
+	command, ok := h.pending()[name]
+	if !ok {
+		return Command{}, false
+	}
+	// The pending command must be available where the interaction was created.
+	if h.devGuild.IsValid() {
+		ok = guildId == h.devGuild
+	} else {
+		ok = command.inScope(discord.NullGuildID) || guildId.IsValid() && command.inScope(guildId)
+	}
+	if ok {
+		h.logger.Debugf("Resolved unknown command ID %v to pending command /%s", id, name)
+	}
+	return command, ok
+}
+
+// commandByName returns the registered command with the name provided that is available in the guild provided. Commands
+// registered to the guild itself take precedence over global commands. h.commandsMu must be held while calling
+// commandByName.
+func (h *Handler) commandByName(name string, guildId discord.GuildID) (Command, bool) {
+	var global Command
+	var foundGlobal bool
+	for _, cmd := range h.commands {
+		if cmd.registeredName() != name {
+			continue
+		}
+		if guildId.IsValid() && cmd.guild == guildId {
+			return cmd, true
+		} else if !cmd.guild.IsValid() {
+			global, foundGlobal = cmd, true
+		}
+	}
+	return global, foundGlobal
+}
+
+// refuse responds to the interaction with the message provided, after the handler refused to execute the command. If
+// the response was already deferred, for example while waiting for a worker, the message is sent as a followup instead.
+func (h *Handler) refuse(i *Interaction, msg string) {
+	defer i.cancel()
+	i.logger.Debugf("Refused to execute command: %s", msg)
+
+	response := MessageResponse{Content: msg, Ephemeral: true}
+	var err error
+	if i.Responded() {
+		_, err = i.followup().Create(response)
+	} else {
+		_, err = i.Respond(response)
+	}
+	if err != nil {
+		i.logger.Errorf("Failed to respond to interaction: %v", err)
+	}
+}
+
+// globalConcurrencyKey is the key under which the executions of all commands are counted by the concurrencyLimiter of
+// the handler. Command names cannot be empty, so it never conflicts with the key of a command.
+const globalConcurrencyKey = ""
+
+// namedCooldown is a Cooldown together with the name under which its uses are stored. This is the name of the command
+// for cooldowns of commands, and the full path for cooldowns of subcommands.
+type namedCooldown struct {
+	name string
+	Cooldown
+}
+
+// takeCooldowns takes a use of all cooldowns provided for the interaction, and counts the execution towards the
+// concurrency limits of the handler and cooldowns. If the command may not be executed, false is returned together with
+// the message to respond with. Otherwise, the function returned must be called once the execution has finished.
+func (h *Handler) takeCooldowns(i *Interaction, cooldowns []namedCooldown) (release func(), msg string, ok bool) {
+	var acquired []string
+	release = func() {
+		for _, name := range acquired {
+			h.running.release(name)
+		}
+	}
+	if h.maxConcurrency > 0 {
+		if !h.running.acquire(globalConcurrencyKey, h.maxConcurrency) {
+			return nil, h.messages.ConcurrencyLimit, false
+		}
+		acquired = append(acquired, globalConcurrencyKey)
+	}
+	for _, c := range cooldowns {
+		if c.MaxConcurrency > 0 {
+			if !h.running.acquire(c.name, c.MaxConcurrency) {
+				release()
+				return nil, h.messages.ConcurrencyLimit, false
+			}
+			acquired = append(acquired, c.name)
+		}
+	}
+	for _, c := range cooldowns {
+		if c.Uses <= 0 || c.Period <= 0 {
+			continue
+		}
+		retryAfter, err := h.cooldowns.Take(c.key(c.name, i), c.Uses, c.Period)
+		if err != nil {
+			// A broken store should not make commands unusable.
+			i.logger.Errorf("Failed to store cooldown: %v", err)
+		} else if retryAfter > 0 {
+			release()
+			return nil, h.messages.Cooldown(retryAfter), false
+		}
+	}
+	return release, "", true
+}
+
+// checkCommand checks whether the user of the interaction is allowed to execute the command and executor where it was
+// executed. If this is not the case, false is returned together with the message to respond with.
+func (h *Handler) checkCommand(discordAPI API, command Command, e any, i *Interaction, owners map[discord.UserID]struct{}) (string, bool) {
+	if _, ok := e.(ownerOnly); ok {
+		if _, ok := owners[i.user.ID]; !ok {
+			return h.messages.OwnerOnly, false
+		}
+	}
+	memberPerms, requiresMemberPerms := e.(PermissionsRequirer)
+	botPerms, requiresBotPerms := e.(BotPermissionsRequirer)
+	if !i.InGuild() {
+		// Permissions only exist within guilds, so executors requiring them can never be executed in direct messages.
+		if _, ok := e.(guildOnly); ok || !command.dmPermission || requiresMemberPerms || requiresBotPerms {
+			return h.messages.DMNotAllowed, false
+		}
+		return "", true
+	}
+	if _, ok := e.(dmOnly); ok {
+		return h.messages.DMOnly, false
+	}
+
+	// The remaining checks need the state of the guild, which can only be done if the API keeps track of it.
+	s, ok := discordAPI.(stateAPI)
+	if !ok {
+		// Permissions required by the executor cannot be checked, so the executor cannot safely be ran.
+		if requiresMemberPerms || requiresBotPerms {
+			i.logger.Warnf("Could not check permissions: the API does not keep track of the state of guilds")
+			return h.messages.PermissionsUnknown, false
+		}
+		return "", true
+	}
+	if requiresMemberPerms {
+		// The member is sent with the interaction, so only the guild and channel have to be found in the state, rather
+		// than fetching the member as well.
+		guild, err := s.Guild(i.guildId)
+		if err != nil {
+			i.logger.Warnf("Could not check permissions of user %v: %v", i.user.ID, err)
+			return h.messages.PermissionsUnknown, false
+		}
+		ch, err := s.Channel(i.channelId)
+		if err != nil {
+			i.logger.Warnf("Could not check permissions of user %v: %v", i.user.ID, err)
+			return h.messages.PermissionsUnknown, false
+		}
+		perms := discord.CalcOverwrites(*guild, *ch, *i.member)
+		if missing := missingPermissions(perms, memberPerms.RequiredPermissions()); missing != 0 {
+			return h.messages.MissingPermissions(missing), false
+		}
+	}
+	if requiresBotPerms {
+		me, err := s.Me()
+		if err != nil {
+			i.logger.Warnf("Could not check permissions of the bot: %v", err)
+			return h.messages.PermissionsUnknown, false
+		}
+		perms, err := s.Permissions(i.channelId, me.ID)
+		if err != nil {
+			i.logger.Warnf("Could not check permissions of the bot: %v", err)
+			return h.messages.PermissionsUnknown, false
+		}
+		if missing := missingPermissions(perms, botPerms.RequiredBotPermissions()); missing != 0 {
+			return h.messages.MissingBotPermissions(missing), false
+		}
+	}
+	if command.nsfw {
+		if ch, err := s.Channel(i.channelId); err == nil && !ch.NSFW {
+			return h.messages.NSFWChannel, false
+		}
+	}
+	return "", true
+}
+
+// scopeName returns a description of the scope of commands registered in the guild provided, for use in log messages.
+func scopeName(guildId discord.GuildID) string {
+	if guildId.IsValid() {
+		return "in guild " + guildId.String()
+	}
+	return "globally"
+}
+
+// interactionPrefix returns the prefix of all messages logged by the logger of an interaction.
+func interactionPrefix(path string, i *Interaction) string {
+	prefix := fmt.Sprintf("[/%s] [interaction %v] [user %v]", path, i.interactionId, i.user.ID)
+	if i.InGuild() {
+		prefix += fmt.Sprintf(" [guild %v]", i.guildId)
+	}
+	return prefix
+}
+
+// applicationOwners returns the IDs of the users that own the application. This is either the owner of the application,
+// or all members of the team that owns it.
+func applicationOwners(app *discord.Application) map[discord.UserID]struct{} {
+	owners := map[discord.UserID]struct{}{}
+	if app.Team != nil {
+		for _, member := range app.Team.Members {
+			owners[member.User.ID] = struct{}{}
+		}
+	} else if app.Owner != nil {
+		owners[app.Owner.ID] = struct{}{}
+	}
+	return owners
 }
@@ -0,0 +1,1045 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// testAppID is the ID of the application of the fakeAPI.
+const testAppID discord.AppID = 1
+
+// fakeAPI is an API that keeps commands in memory and records the responses sent to interactions, so that the handler
+// can be tested without connecting to discord.
+type fakeAPI struct {
+	mu sync.Mutex
+	// commands contains the commands registered in every scope.
+	commands map[discord.GuildID][]discord.Command
+	nextID   discord.CommandID
+	// calls contains the names of all methods called that change commands, in order.
+	calls []string
+	// responses, followups, edits and deletes record the messages sent to interactions.
+	responses []api.InteractionResponse
+	followups []api.InteractionResponseData
+	edits     []api.EditInteractionResponseData
+	deletes   int
+	// respondErr is returned by RespondInteraction if it is not nil.
+	respondErr error
+	// hold is sent to before creating or overwriting commands and received from afterwards, if it is not nil, so that
+	// tests can make changes while the handler is waiting for discord.
+	hold     chan struct{}
+	handlers []any
+}
+
+// newFakeAPI returns a new fakeAPI without any commands.
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{commands: map[discord.GuildID][]discord.Command{}, nextID: 100}
+}
+
+// CurrentApplication ...
+func (f *fakeAPI) CurrentApplication() (*discord.Application, error) {
+	return &discord.Application{ID: testAppID, Owner: &discord.User{ID: 1}}, nil
+}
+
+// AddHandler ...
+func (f *fakeAPI) AddHandler(handler interface{}) (rm func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, handler)
+	i := len(f.handlers) - 1
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.handlers[i] = nil
+	}
+}
+
+// RespondInteraction ...
+func (f *fakeAPI) RespondInteraction(_ discord.InteractionID, _ string, resp api.InteractionResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.respondErr != nil {
+		return f.respondErr
+	}
+	f.responses = append(f.responses, resp)
+	return nil
+}
+
+// InteractionResponse ...
+func (f *fakeAPI) InteractionResponse(discord.AppID, string) (*discord.Message, error) {
+	return &discord.Message{}, nil
+}
+
+// EditInteractionResponse ...
+func (f *fakeAPI) EditInteractionResponse(_ discord.AppID, _ string, data api.EditInteractionResponseData) (*discord.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, data)
+	return &discord.Message{}, nil
+}
+
+// DeleteInteractionResponse ...
+func (f *fakeAPI) DeleteInteractionResponse(discord.AppID, string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes++
+	return nil
+}
+
+// CreateInteractionFollowup ...
+func (f *fakeAPI) CreateInteractionFollowup(_ discord.AppID, _ string, data api.InteractionResponseData) (*discord.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.followups = append(f.followups, data)
+	return &discord.Message{}, nil
+}
+
+// EditInteractionFollowup ...
+func (f *fakeAPI) EditInteractionFollowup(discord.AppID, discord.MessageID, string, api.EditInteractionResponseData) (*discord.Message, error) {
+	return &discord.Message{}, nil
+}
+
+// DeleteInteractionFollowup ...
+func (f *fakeAPI) DeleteInteractionFollowup(discord.AppID, discord.MessageID, string) error {
+	return nil
+}
+
+// Commands ...
+func (f *fakeAPI) Commands(appID discord.AppID) ([]discord.Command, error) {
+	return f.GuildCommands(appID, discord.NullGuildID)
+}
+
+// GuildCommands ...
+func (f *fakeAPI) GuildCommands(_ discord.AppID, guildID discord.GuildID) ([]discord.Command, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]discord.Command(nil), f.commands[guildID]...), nil
+}
+
+// CreateCommand ...
+func (f *fakeAPI) CreateCommand(appID discord.AppID, data api.CreateCommandData) (*discord.Command, error) {
+	return f.CreateGuildCommand(appID, discord.NullGuildID, data)
+}
+
+// CreateGuildCommand ...
+func (f *fakeAPI) CreateGuildCommand(_ discord.AppID, guildID discord.GuildID, data api.CreateCommandData) (*discord.Command, error) {
+	f.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "create /"+data.Name)
+	cmd := f.command(guildID, data)
+	f.commands[guildID] = append(f.commands[guildID], cmd)
+	return &cmd, nil
+}
+
+// EditCommand ...
+func (f *fakeAPI) EditCommand(appID discord.AppID, commandID discord.CommandID, data api.CreateCommandData) (*discord.Command, error) {
+	return f.EditGuildCommand(appID, discord.NullGuildID, commandID, data)
+}
+
+// EditGuildCommand ...
+func (f *fakeAPI) EditGuildCommand(_ discord.AppID, guildID discord.GuildID, commandID discord.CommandID, data api.CreateCommandData) (*discord.Command, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "edit /"+data.Name)
+	for i, cmd := range f.commands[guildID] {
+		if cmd.ID == commandID {
+			edited := f.command(guildID, data)
+			edited.ID = commandID
+			f.commands[guildID][i] = edited
+			return &edited, nil
+		}
+	}
+	return nil, errors.New("unknown command")
+}
+
+// DeleteCommand ...
+func (f *fakeAPI) DeleteCommand(appID discord.AppID, commandID discord.CommandID) error {
+	return f.DeleteGuildCommand(appID, discord.NullGuildID, commandID)
+}
+
+// DeleteGuildCommand ...
+func (f *fakeAPI) DeleteGuildCommand(_ discord.AppID, guildID discord.GuildID, commandID discord.CommandID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, cmd := range f.commands[guildID] {
+		if cmd.ID == commandID {
+			f.calls = append(f.calls, "delete /"+cmd.Name)
+			f.commands[guildID] = append(f.commands[guildID][:i:i], f.commands[guildID][i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("unknown command")
+}
+
+// BulkOverwriteCommands ...
+func (f *fakeAPI) BulkOverwriteCommands(appID discord.AppID, commands []api.CreateCommandData) ([]discord.Command, error) {
+	return f.BulkOverwriteGuildCommands(appID, discord.NullGuildID, commands)
+}
+
+// BulkOverwriteGuildCommands ...
+func (f *fakeAPI) BulkOverwriteGuildCommands(_ discord.AppID, guildID discord.GuildID, commands []api.CreateCommandData) ([]discord.Command, error) {
+	f.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "overwrite "+scopeName(guildID))
+	registered := make([]discord.Command, 0, len(commands))
+	for _, data := range commands {
+		registered = append(registered, f.command(guildID, data))
+	}
+	f.commands[guildID] = registered
+	return append([]discord.Command(nil), registered...), nil
+}
+
+// wait waits until the test allows the API to continue, if the API is held.
+func (f *fakeAPI) wait() {
+	if f.hold != nil {
+		f.hold <- struct{}{}
+		<-f.hold
+	}
+}
+
+// command returns a new command with the data provided. f.mu must be held while calling command.
+func (f *fakeAPI) command(guildID discord.GuildID, data api.CreateCommandData) discord.Command {
+	f.nextID++
+	return discord.Command{
+		ID:          f.nextID,
+		Type:        discord.ChatInputCommand,
+		AppID:       testAppID,
+		GuildID:     guildID,
+		Name:        data.Name,
+		Description: data.Description,
+		Options:     data.Options,
+	}
+}
+
+// names returns the names of the commands registered in the scope provided.
+func (f *fakeAPI) names(guildID discord.GuildID) (names []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, cmd := range f.commands[guildID] {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+// responseCount returns the amount of responses sent to interactions.
+func (f *fakeAPI) responseCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.responses)
+}
+
+// newEvent returns an event for the command with the name provided, executed by the user provided in the guild
+// provided. The command has an ID the handler does not know, so that it is looked up by its name.
+func newEvent(name string, guildID discord.GuildID, userID discord.UserID) *gateway.InteractionCreateEvent {
+	event := &gateway.InteractionCreateEvent{InteractionEvent: discord.InteractionEvent{
+		ID:        discord.InteractionID(discord.NewSnowflake(time.Now())),
+		AppID:     testAppID,
+		ChannelID: 10,
+		Token:     "token",
+		GuildID:   guildID,
+		Data:      &discord.CommandInteraction{ID: 1, Name: name},
+	}}
+	user := discord.User{ID: userID, Username: "user"}
+	if guildID.IsValid() {
+		event.Member = &discord.Member{User: user}
+	} else {
+		event.User = &user
+	}
+	return event
+}
+
+// funcCommand returns a command that runs the function provided instead of its executor. Executors are created anew
+// for every execution, so the function is ran by middleware of the command.
+func funcCommand(name string, f func(i *Interaction) error) Command {
+	return New(name, "A test command.").WithExecutor(testExecutor{}).WithMiddleware(func(ExecuteFunc) ExecuteFunc {
+		return func(e Execution) error {
+			return f(e.Interaction)
+		}
+	})
+}
+
+func TestBusyDefer(t *testing.T) {
+	unblock := make(chan struct{})
+	var executed sync.WaitGroup
+	executed.Add(3)
+	h := NewHandler(nil).
+		WithWorkers(1, 1, BusyDefer).
+		WithCommands(funcCommand("slow", func(i *Interaction) error {
+			<-unblock
+			executed.Done()
+			return nil
+		}))
+	fake := newFakeAPI()
+
+	// The first interaction occupies the only worker and the second one the queue, so the third one has to wait.
+	for i := 0; i < 3; i++ {
+		returned := make(chan struct{})
+		go func() {
+			h.handleInteraction(fake, testAppID, nil, newEvent("slow", discord.NullGuildID, 2))
+			close(returned)
+		}()
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Fatalf("handling interaction %d blocked the event handler", i)
+		}
+		if i == 0 {
+			// Wait until the worker picked up the first interaction, so that the second one is queued.
+			for stats, _ := h.PoolStats(); stats.Busy == 0; stats, _ = h.PoolStats() {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	// The response to the interaction waiting for room in the queue is deferred.
+	deadline := time.Now().Add(time.Second)
+	for fake.responseCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := fake.responseCount(); n != 1 || fake.responses[0].Type != api.DeferredMessageInteractionWithSource {
+		t.Fatalf("responses = %+v, want a single deferred response", fake.responses)
+	}
+
+	close(unblock)
+	executed.Wait()
+	if stats, _ := h.PoolStats(); stats.Rejected != 1 {
+		t.Errorf("stats = %+v, want 1 rejected", stats)
+	}
+}
+
+// dispatch calls all handlers added to the API that accept the event provided, in the same way as the gateway does.
+func (f *fakeAPI) dispatch(event any) {
+	f.mu.Lock()
+	handlers := append([]any(nil), f.handlers...)
+	f.mu.Unlock()
+	for _, handler := range handlers {
+		if handler == nil {
+			continue
+		}
+		v := reflect.ValueOf(handler)
+		if v.Type().In(0) == reflect.TypeOf(event) {
+			v.Call([]reflect.Value{reflect.ValueOf(event)})
+		}
+	}
+}
+
+func TestGuildCreateAsync(t *testing.T) {
+	const guildID discord.GuildID = 5
+	fake := newFakeAPI()
+	fake.hold = make(chan struct{})
+	h := NewHandler(nil).WithGuildPolicy(func(discord.Guild) []Command {
+		return []Command{New("ping", "Pings the bot.").WithExecutor(testExecutor{})}
+	})
+	if err := h.Listen(fake); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		fake.dispatch(&gateway.GuildCreateEvent{Guild: discord.Guild{ID: guildID}})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("registering commands blocked the event handler")
+	}
+
+	// Registering the commands finishes once discord responds.
+	<-fake.hold
+	fake.hold <- struct{}{}
+	deadline := time.Now().Add(time.Second)
+	for len(h.Commands()) == 0 || !h.Commands()[0].Registered {
+		if time.Now().After(deadline) {
+			t.Fatalf("commands = %+v, want /ping registered in guild %v", h.Commands(), guildID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := fake.names(guildID); !reflect.DeepEqual(got, []string{"ping"}) {
+		t.Errorf("registered commands = %v, want [ping]", got)
+	}
+}
+
+// waitFor waits until the condition provided is met, and fails the test if that takes longer than a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGuildPolicyKeepsSyncedCommands(t *testing.T) {
+	const guildID discord.GuildID = 5
+	fake := newFakeAPI()
+	h := NewHandler(nil).
+		WithCommands(New("staff", "Staff only.").WithExecutor(testExecutor{}).WithGuilds(guildID)).
+		WithGuildPolicy(func(discord.Guild) []Command {
+			return []Command{New("ping", "Pings the bot.").WithExecutor(testExecutor{})}
+		})
+	if _, err := h.Sync(fake, SyncOptions{}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := h.Listen(fake); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	// Guilds become available again every time the bot reconnects.
+	for i := 0; i < 2; i++ {
+		fake.mu.Lock()
+		calls := len(fake.calls)
+		fake.mu.Unlock()
+		fake.dispatch(&gateway.GuildCreateEvent{Guild: discord.Guild{ID: guildID}})
+		waitFor(t, "commands to be registered", func() bool {
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			return len(fake.calls) > calls
+		})
+
+		got := fake.names(guildID)
+		sort.Strings(got)
+		if want := []string{"ping", "staff"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("commands registered in guild = %v, want %v", got, want)
+		}
+		waitFor(t, "commands to be bound", func() bool {
+			return len(h.Commands()) == 2
+		})
+		for _, info := range h.Commands() {
+			if !info.Registered || info.Guild != guildID {
+				t.Errorf("command /%s registered %v in guild %v, want registered in guild %v", info.Name, info.Registered, info.Guild, guildID)
+			}
+		}
+	}
+}
+
+func TestRegisterKeepsReplacedCommands(t *testing.T) {
+	fake := newFakeAPI()
+	fake.hold = make(chan struct{})
+	h := NewHandler(nil).WithCommands(New("ping", "Pings the bot.").WithExecutor(testExecutor{}))
+
+	done := make(chan error)
+	go func() {
+		done <- h.RegisterAll(fake)
+	}()
+	// The command is replaced while the handler is waiting for discord to register the old one.
+	<-fake.hold
+	h.OverrideCommands(New("ping", "Pings the bot again.").WithExecutor(testExecutor{}))
+	fake.hold <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("RegisterAll: %v", err)
+	}
+
+	var pending []string
+	for _, info := range h.Commands() {
+		if !info.Registered {
+			pending = append(pending, info.Name)
+		}
+	}
+	if !reflect.DeepEqual(pending, []string{"ping"}) {
+		t.Errorf("pending commands = %v, want [ping]", pending)
+	}
+}
+
+func TestSyncDoesNotBlockDispatch(t *testing.T) {
+	fake := newFakeAPI()
+	executed := make(chan struct{}, 1)
+	h := NewHandler(nil).WithCommands(funcCommand("ping", func(*Interaction) error {
+		executed <- struct{}{}
+		return nil
+	}))
+	if err := h.RegisterAll(fake); err != nil {
+		t.Fatalf("RegisterAll: %v", err)
+	}
+
+	fake.hold = make(chan struct{})
+	h.WithCommands(New("echo", "Echoes text.").WithExecutor(testExecutor{}))
+	done := make(chan error)
+	go func() {
+		_, err := h.SyncAll(fake, SyncOptions{})
+		done <- err
+	}()
+
+	// While waiting for discord to create /echo, /ping can still be executed.
+	<-fake.hold
+	go h.handleInteraction(fake, testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("synchronising commands blocked executing commands")
+	}
+	fake.hold <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+
+	var registered []string
+	for _, info := range h.Commands() {
+		if info.Registered {
+			registered = append(registered, info.Name)
+		}
+	}
+	if want := []string{"echo", "ping"}; !reflect.DeepEqual(registered, want) {
+		t.Errorf("registered commands = %v, want %v", registered, want)
+	}
+}
+
+func TestSyncAllScopes(t *testing.T) {
+	const guildID discord.GuildID = 5
+	fake := newFakeAPI()
+	executed := make(chan struct{}, 1)
+	h := NewHandler(nil).WithCommands(funcCommand("ping", func(*Interaction) error {
+		executed <- struct{}{}
+		return nil
+	}))
+	if err := h.RegisterAll(fake); err != nil {
+		t.Fatalf("RegisterAll: %v", err)
+	}
+
+	fake.hold = make(chan struct{})
+	h.WithCommands(New("staff", "Staff only.").WithExecutor(testExecutor{}).WithGuilds(guildID))
+	done := make(chan error)
+	go func() {
+		_, err := h.Sync(fake, SyncOptions{})
+		done <- err
+	}()
+
+	// While waiting for discord to create /staff, /ping can still be executed, and the command can be replaced.
+	<-fake.hold
+	go h.handleInteraction(fake, testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("synchronising commands blocked executing commands")
+	}
+	h.OverrideCommands(New("staff", "Staff only, again.").WithExecutor(testExecutor{}).WithGuilds(guildID))
+	fake.hold <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	var registered, pending []string
+	for _, info := range h.Commands() {
+		if info.Registered {
+			registered = append(registered, info.Name+" "+scopeName(info.Guild))
+		} else {
+			pending = append(pending, info.Name)
+		}
+	}
+	if want := []string{"staff in guild 5", "ping globally"}; !reflect.DeepEqual(registered, want) {
+		t.Errorf("registered commands = %v, want %v", registered, want)
+	}
+	if want := []string{"staff"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("pending commands = %v, want %v", pending, want)
+	}
+}
+
+// memoryHashStore is a HashStore that keeps registrations in memory.
+type memoryHashStore struct {
+	mu            sync.Mutex
+	registrations map[discord.GuildID]Registration
+}
+
+// Registration ...
+func (s *memoryHashStore) Registration(guildId discord.GuildID) (Registration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.registrations[guildId]
+	return r, ok, nil
+}
+
+// SetRegistration ...
+func (s *memoryHashStore) SetRegistration(guildId discord.GuildID, r Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registrations[guildId] = r
+	return nil
+}
+
+func TestSyncInvalidatesRegistration(t *testing.T) {
+	fake := newFakeAPI()
+	store := &memoryHashStore{registrations: map[discord.GuildID]Registration{}}
+	ping := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+
+	h := NewHandler(nil).WithHashStore(store).WithCommands(ping)
+	if err := h.RegisterAll(fake); err != nil {
+		t.Fatalf("RegisterAll: %v", err)
+	}
+	h.WithCommands(New("echo", "Echoes text.").WithExecutor(testExecutor{}))
+	if _, err := h.SyncAll(fake, SyncOptions{}); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+
+	// After a restart, registering only /ping again must remove /echo, even though /ping did not change.
+	h = NewHandler(nil).WithHashStore(store).WithCommands(ping)
+	if err := h.RegisterAll(fake); err != nil {
+		t.Fatalf("RegisterAll: %v", err)
+	}
+	if got := fake.names(discord.NullGuildID); !reflect.DeepEqual(got, []string{"ping"}) {
+		t.Errorf("registered commands = %v, want [ping]", got)
+	}
+}
+
+// dmOnlyExecutor is an executor that can only be executed in direct messages.
+type dmOnlyExecutor struct {
+	DMOnly
+	Text string `description:"Some text."`
+}
+
+// Run ...
+func (dmOnlyExecutor) Run(*Interaction) {}
+
+func TestPartialMessages(t *testing.T) {
+	h := NewHandler(nil).
+		WithMessages(Messages{InternalError: "Oops."}).
+		WithCommands(New("dm", "Only works in direct messages.").WithExecutor(dmOnlyExecutor{}))
+	fake := newFakeAPI()
+
+	h.handleInteraction(fake, testAppID, nil, newEvent("dm", 5, 2))
+	waitFor(t, "refusal", func() bool { return fake.responseCount() == 1 })
+	if got := fake.responses[0].Data.Content; got == nil || got.Val != DefaultMessages.DMOnly {
+		t.Errorf("response content = %v, want %q", got, DefaultMessages.DMOnly)
+	}
+	if h.messages.Restarting != "" {
+		t.Errorf("Restarting = %q, want it to stay empty", h.messages.Restarting)
+	}
+}
+
+func TestCloseInExecutor(t *testing.T) {
+	closed := make(chan error, 1)
+	var h *Handler
+	h = NewHandler(nil).WithCommands(funcCommand("stop", func(i *Interaction) error {
+		if err := h.Close(i.Context()); !errors.Is(err, ErrCloseInExecutor) {
+			t.Errorf("Close() = %v, want %v", err, ErrCloseInExecutor)
+		}
+		go func() {
+			closed <- h.Close(context.Background())
+		}()
+		return nil
+	}))
+
+	h.handleInteraction(newFakeAPI(), testAppID, nil, newEvent("stop", discord.NullGuildID, 2))
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() on a new goroutine did not return")
+	}
+}
+
+// fakeStateAPI is a fakeAPI that keeps track of a single guild with a single channel.
+type fakeStateAPI struct {
+	*fakeAPI
+	guild   discord.Guild
+	channel discord.Channel
+}
+
+// Permissions ...
+func (f *fakeStateAPI) Permissions(discord.ChannelID, discord.UserID) (discord.Permissions, error) {
+	return 0, errors.New("members are not cached")
+}
+
+// Guild ...
+func (f *fakeStateAPI) Guild(discord.GuildID) (*discord.Guild, error) {
+	return &f.guild, nil
+}
+
+// Channel ...
+func (f *fakeStateAPI) Channel(discord.ChannelID) (*discord.Channel, error) {
+	return &f.channel, nil
+}
+
+// Me ...
+func (f *fakeStateAPI) Me() (*discord.User, error) {
+	return &discord.User{ID: 3}, nil
+}
+
+// manageMessagesExecutor is an executor that requires the member executing it to be able to manage messages.
+type manageMessagesExecutor struct {
+	Text string `description:"Some text."`
+}
+
+// Run ...
+func (manageMessagesExecutor) Run(*Interaction) {}
+
+// RequiredPermissions ...
+func (manageMessagesExecutor) RequiredPermissions() discord.Permissions {
+	return discord.PermissionManageMessages
+}
+
+func TestMemberPermissions(t *testing.T) {
+	const moderator = 7
+	fake := &fakeStateAPI{
+		fakeAPI: newFakeAPI(),
+		guild: discord.Guild{ID: 5, OwnerID: 1, Roles: []discord.Role{
+			{ID: 5, Permissions: discord.PermissionSendMessages},
+			{ID: moderator, Permissions: discord.PermissionManageMessages},
+		}},
+		channel: discord.Channel{ID: 10, GuildID: 5},
+	}
+	h := NewHandler(nil).WithCommands(New("purge", "Removes messages.").WithExecutor(manageMessagesExecutor{}))
+
+	tests := []struct {
+		name  string
+		roles []discord.RoleID
+		want  string
+	}{
+		{name: "missing permissions", want: DefaultMessages.MissingPermissions(discord.PermissionManageMessages)},
+		{name: "role permissions", roles: []discord.RoleID{moderator}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake.responses = nil
+			event := newEvent("purge", 5, 2)
+			event.Member.RoleIDs = test.roles
+			h.handleInteraction(fake, testAppID, nil, event)
+
+			var got string
+			if len(fake.responses) > 0 && fake.responses[0].Data != nil && fake.responses[0].Data.Content != nil {
+				got = fake.responses[0].Data.Content.Val
+			}
+			if got != test.want {
+				t.Errorf("response = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// content returns the content of the response with the index provided, or an empty string if there is none.
+func (f *fakeAPI) content(i int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i >= len(f.responses) || f.responses[i].Data == nil || f.responses[i].Data.Content == nil {
+		return ""
+	}
+	return f.responses[i].Data.Content.Val
+}
+
+func TestBusyRejectKeepsCooldown(t *testing.T) {
+	unblock := make(chan struct{})
+	executed := make(chan struct{}, 2)
+	h := NewHandler(nil).
+		WithWorkers(1, 1, BusyReject).
+		WithCommands(
+			funcCommand("slow", func(*Interaction) error {
+				<-unblock
+				return nil
+			}),
+			funcCommand("limited", func(*Interaction) error {
+				executed <- struct{}{}
+				return nil
+			}).WithCooldown(Cooldown{Uses: 1, Period: time.Minute}),
+		)
+	fake := newFakeAPI()
+
+	// The first interaction occupies the only worker and the second one the queue.
+	h.handleInteraction(fake, testAppID, nil, newEvent("slow", discord.NullGuildID, 1))
+	waitFor(t, "busy worker", func() bool { stats, _ := h.PoolStats(); return stats.Busy == 1 })
+	h.handleInteraction(fake, testAppID, nil, newEvent("slow", discord.NullGuildID, 1))
+	h.handleInteraction(fake, testAppID, nil, newEvent("limited", discord.NullGuildID, 2))
+	if got := fake.content(0); got != DefaultMessages.Busy {
+		t.Fatalf("response = %q, want %q", got, DefaultMessages.Busy)
+	}
+	close(unblock)
+	waitFor(t, "idle worker", func() bool { stats, _ := h.PoolStats(); return stats.Executed == 2 })
+
+	// The rejected interaction did not use up the only use of the cooldown.
+	h.handleInteraction(fake, testAppID, nil, newEvent("limited", discord.NullGuildID, 2))
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("command was not executed after being rejected")
+	}
+	waitFor(t, "idle worker", func() bool { stats, _ := h.PoolStats(); return stats.Executed == 3 })
+	h.handleInteraction(fake, testAppID, nil, newEvent("limited", discord.NullGuildID, 2))
+	waitFor(t, "cooldown response", func() bool { return fake.responseCount() == 2 })
+	if got := fake.content(1); got != DefaultMessages.Cooldown(time.Minute) {
+		t.Errorf("response = %q, want %q", got, DefaultMessages.Cooldown(time.Minute))
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	unblock := make(chan struct{})
+	running := make(chan struct{})
+	h := NewHandler(nil).
+		WithMaxConcurrency(1).
+		WithCommands(
+			funcCommand("slow", func(*Interaction) error {
+				close(running)
+				<-unblock
+				return nil
+			}),
+			funcCommand("ping", func(*Interaction) error { return nil }),
+		)
+	fake := newFakeAPI()
+
+	go h.handleInteraction(fake, testAppID, nil, newEvent("slow", discord.NullGuildID, 1))
+	<-running
+	h.handleInteraction(fake, testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+	if got := fake.content(0); got != DefaultMessages.ConcurrencyLimit {
+		t.Errorf("response = %q, want %q", got, DefaultMessages.ConcurrencyLimit)
+	}
+	close(unblock)
+
+	waitFor(t, "released concurrency slot", func() bool {
+		h.running.mu.Lock()
+		defer h.running.mu.Unlock()
+		return h.running.running[globalConcurrencyKey] == 0
+	})
+	h.handleInteraction(fake, testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+	if n := fake.responseCount(); n != 1 {
+		t.Errorf("%d responses, want 1", n)
+	}
+}
+
+func TestDoubleResponsePolicy(t *testing.T) {
+	respond := func(i *Interaction) error {
+		_, err := i.Respond(MessageResponse{Content: "Pong!"})
+		return err
+	}
+	respondEphemeral := func(i *Interaction) error {
+		_, err := i.Respond(MessageResponse{Content: "Pong!", Ephemeral: true})
+		return err
+	}
+	deferResponse := func(i *Interaction) error {
+		_, err := i.DeferResponse()
+		return err
+	}
+	autoDefer := func(i *Interaction) error {
+		return i.autoDefer(false)
+	}
+
+	tests := []struct {
+		name   string
+		policy DoubleResponsePolicy
+		steps  []func(i *Interaction) error
+		// errs holds the error expected from every step.
+		errs                      []error
+		edits, followups, deletes int
+	}{{
+		name:   "error",
+		policy: DoubleResponseError,
+		steps:  []func(i *Interaction) error{respond, respond},
+		errs:   []error{nil, ErrAlreadyResponded},
+	}, {
+		name:   "error after deferring",
+		policy: DoubleResponseError,
+		steps:  []func(i *Interaction) error{deferResponse, respond, deferResponse},
+		errs:   []error{nil, ErrAlreadyResponded, ErrAlreadyResponded},
+	}, {
+		name:   "error after automatically deferring",
+		policy: DoubleResponseError,
+		steps:  []func(i *Interaction) error{autoDefer, respond, respond},
+		errs:   []error{nil, nil, ErrAlreadyResponded},
+		edits:  1,
+	}, {
+		name:      "ephemeral after automatically deferring",
+		policy:    DoubleResponseError,
+		steps:     []func(i *Interaction) error{autoDefer, respondEphemeral},
+		errs:      []error{nil, nil},
+		followups: 1,
+		deletes:   1,
+	}, {
+		name:   "edit deferred",
+		policy: DoubleResponseEditDeferred,
+		steps:  []func(i *Interaction) error{deferResponse, respond, respond},
+		errs:   []error{nil, nil, ErrAlreadyResponded},
+		edits:  1,
+	}, {
+		name:   "edit without deferring",
+		policy: DoubleResponseEditDeferred,
+		steps:  []func(i *Interaction) error{respond, respond},
+		errs:   []error{nil, ErrAlreadyResponded},
+	}, {
+		name:      "followup",
+		policy:    DoubleResponseFollowup,
+		steps:     []func(i *Interaction) error{respond, respond, respond},
+		errs:      []error{nil, nil, nil},
+		followups: 2,
+	}, {
+		name:      "followup after deferring",
+		policy:    DoubleResponseFollowup,
+		steps:     []func(i *Interaction) error{deferResponse, deferResponse, respond, respond},
+		errs:      []error{nil, nil, nil, nil},
+		edits:     1,
+		followups: 1,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var errs []error
+			h := NewHandler(nil).
+				WithDoubleResponsePolicy(test.policy).
+				WithCommands(funcCommand("ping", func(i *Interaction) error {
+					for _, step := range test.steps {
+						errs = append(errs, step(i))
+					}
+					return nil
+				}))
+			fake := newFakeAPI()
+			h.handleInteraction(fake, testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+
+			if !reflect.DeepEqual(errs, test.errs) {
+				t.Errorf("errors = %v, want %v", errs, test.errs)
+			}
+			if n := len(fake.responses); n != 1 {
+				t.Errorf("%d responses, want 1", n)
+			}
+			if n := len(fake.edits); n != test.edits {
+				t.Errorf("%d edits, want %d", n, test.edits)
+			}
+			if n := len(fake.followups); n != test.followups {
+				t.Errorf("%d followups, want %d", n, test.followups)
+			}
+			if fake.deletes != test.deletes {
+				t.Errorf("%d deletes, want %d", fake.deletes, test.deletes)
+			}
+		})
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	h := NewHandler(nil).
+		WithMessages(Messages{Restarting: "Restarting."}).
+		WithCommands(funcCommand("wait", func(i *Interaction) error {
+			close(started)
+			<-i.Context().Done()
+			close(finished)
+			return nil
+		}))
+	fake := newFakeAPI()
+
+	go h.handleInteraction(fake, testAppID, nil, newEvent("wait", discord.NullGuildID, 2))
+	<-started
+	// Closing cancels the context of the executor, and waits for it to return.
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Close() returned before the executor did")
+	}
+
+	h.handleInteraction(fake, testAppID, nil, newEvent("wait", discord.NullGuildID, 2))
+	if got := fake.content(0); got != "Restarting." {
+		t.Errorf("response = %q, want %q", got, "Restarting.")
+	}
+	if err := h.Close(context.Background()); !errors.Is(err, ErrHandlerClosed) {
+		t.Errorf("Close() = %v, want %v", err, ErrHandlerClosed)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	h := NewHandler(nil).WithCommands(funcCommand("stuck", func(*Interaction) error {
+		close(started)
+		<-unblock
+		return nil
+	}))
+	fake := newFakeAPI()
+	defer close(unblock)
+
+	go h.handleInteraction(fake, testAppID, nil, newEvent("stuck", discord.NullGuildID, 2))
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Without Messages.Restarting, interactions are not responded to after closing.
+	h.handleInteraction(fake, testAppID, nil, newEvent("stuck", discord.NullGuildID, 2))
+	if n := fake.responseCount(); n != 0 {
+		t.Errorf("%d responses, want 0", n)
+	}
+}
+
+func TestCloseFlushesCooldowns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cooldowns.json")
+	store, err := NewFileCooldownStore(path)
+	if err != nil {
+		t.Fatalf("NewFileCooldownStore: %v", err)
+	}
+	defer store.Close()
+	h := NewHandler(nil).
+		WithCooldownStore(store).
+		WithCommands(funcCommand("ping", func(*Interaction) error { return nil }).WithCooldown(Cooldown{Uses: 1, Period: time.Hour}))
+
+	h.handleInteraction(newFakeAPI(), testAppID, nil, newEvent("ping", discord.NullGuildID, 2))
+	if err := h.Close(context.Background()); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("cooldowns were not written when closing the handler: %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	bound := func(c Command, guildId discord.GuildID) Command {
+		c.guild, c.registered = guildId, true
+		return c
+	}
+	ping := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+	guildPing := New("ping", "Pings the guild.").WithExecutor(testExecutor{})
+	echo := New("echo", "Echoes text.").WithExecutor(testExecutor{})
+	staff := New("staff", "Staff commands.").WithExecutor(testExecutor{}).WithGuilds(5)
+
+	h := NewHandler(nil).WithCommands(echo, staff)
+	h.commands[10] = bound(ping, discord.NullGuildID)
+	h.commands[11] = bound(guildPing, 5)
+	dev := NewHandler(nil).WithDevGuild(7, "dev-").WithCommands(echo)
+
+	tests := []struct {
+		name    string
+		h       *Handler
+		id      discord.CommandID
+		command string
+		guildId discord.GuildID
+		want    string
+	}{
+		{name: "by ID", h: h, id: 10, command: "other", guildId: 5, want: ping.description},
+		{name: "guild command by ID", h: h, id: 11, command: "ping", guildId: 6, want: guildPing.description},
+		{name: "guild command by name", h: h, command: "ping", guildId: 5, want: guildPing.description},
+		{name: "global command by name in guild", h: h, command: "ping", guildId: 6, want: ping.description},
+		{name: "global command by name in direct messages", h: h, command: "ping", want: ping.description},
+		{name: "pending global command", h: h, command: "echo", guildId: 6, want: echo.description},
+		{name: "pending guild command", h: h, command: "staff", guildId: 5, want: staff.description},
+		{name: "pending guild command in other guild", h: h, command: "staff", guildId: 6},
+		{name: "pending guild command in direct messages", h: h, command: "staff"},
+		{name: "unknown command", h: h, command: "missing", guildId: 5},
+		{name: "pending development command", h: dev, command: "dev-echo", guildId: 7, want: echo.description},
+		{name: "pending development command in other guild", h: dev, command: "dev-echo", guildId: 5},
+		{name: "pending development command without prefix", h: dev, command: "echo", guildId: 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := test.id
+			if id == 0 {
+				id = 99
+			}
+			command, ok := test.h.lookup(id, test.command, test.guildId)
+			if ok != (test.want != "") || ok && command.description != test.want {
+				t.Errorf("lookup() = %q, %v, want %q", command.description, ok, test.want)
+			}
+		})
+	}
+}
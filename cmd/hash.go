@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// HashCommands returns a hash of the data sent to discord when registering the commands provided. Commands that result
+// in the same data always have the same hash, regardless of the order in which they are provided.
+func HashCommands(commands ...Command) (string, error) {
+	data := make([]commandData, 0, len(commands))
+	for _, cmd := range commands {
+		data = append(data, cmd.marshal())
+	}
+	return hashCommandData(data)
+}
+
+// hashCommandData returns a hash of the marshalled commands provided. The commands are sorted by name first, so that the
+// order of the commands does not change the hash.
+func hashCommandData(data []commandData) (string, error) {
+	sorted := make([]commandData, len(data))
+	copy(sorted, data)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	b, err := json.Marshal(sorted)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Registration is a set of commands registered to discord in a single scope, as stored in a HashStore.
+type Registration struct {
+	// Hash is the hash of the commands registered, as returned by HashCommands.
+	Hash string `json:"hash"`
+	// Commands contains the IDs of the commands registered by their name.
+	Commands map[string]discord.CommandID `json:"commands"`
+}
+
+// HashStore stores the last Registration of commands in every scope, so that Handler.RegisterAll and
+// Handler.RegisterAllGuild can skip registering commands that have not changed since. The global scope is identified
+// by discord.NullGuildID. A HashStore must be safe for concurrent use.
+type HashStore interface {
+	// Registration returns the last Registration in the scope provided. If there is none, false is returned.
+	Registration(guildId discord.GuildID) (Registration, bool, error)
+	// SetRegistration stores the Registration provided as the last Registration in the scope provided.
+	SetRegistration(guildId discord.GuildID, r Registration) error
+}
+
+// FileHashStore is a HashStore that keeps all registrations in a JSON file, so that they survive restarts.
+type FileHashStore struct {
+	mu            sync.Mutex
+	path          string
+	registrations map[string]Registration
+}
+
+// NewFileHashStore returns a new FileHashStore that stores registrations in the file at the path provided. If the file
+// exists, the registrations in it are loaded.
+func NewFileHashStore(path string) (*FileHashStore, error) {
+	s := &FileHashStore{path: path, registrations: map[string]Registration{}}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &s.registrations); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Registration ...
+func (s *FileHashStore) Registration(guildId discord.GuildID) (Registration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.registrations[scopeKey(guildId)]
+	return r, ok, nil
+}
+
+// SetRegistration ...
+func (s *FileHashStore) SetRegistration(guildId discord.GuildID, r Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.registrations[scopeKey(guildId)] = r
+	b, err := json.Marshal(s.registrations)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// scopeKey returns the key under which the registration of the scope provided is stored.
+func scopeKey(guildId discord.GuildID) string {
+	if guildId.IsValid() {
+		return guildId.String()
+	}
+	return "global"
+}
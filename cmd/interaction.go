@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
 	"go.uber.org/atomic"
+	"sync"
+	"time"
 )
 
 // Interaction will be passed to the command executor when a command is executed by a user. It contains details about
@@ -22,42 +25,141 @@ type Interaction struct {
 	channelId discord.ChannelID
 	member    *discord.Member
 	user      *discord.User
+	logger    Logger
 
-	hasResponded atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	expires time.Time
+
+	// respondMu is held while sending the initial response to the interaction, so that the handler and the executor
+	// cannot both send one at the same time.
+	respondMu    sync.Mutex
+	responseType atomic.Uint32
+	autoDeferred atomic.Bool
+	// deferPending is true while the response to the interaction has been deferred, but the original response has not
+	// yet been edited to replace the loading state.
+	deferPending atomic.Bool
+	// deferEphemeral is true if the response to the interaction was deferred as ephemeral.
+	deferEphemeral atomic.Bool
+	// policy decides what happens when a response is sent to the interaction after it has already been responded to.
+	policy DoubleResponsePolicy
 }
 
+// ResponseType is the type of the initial response sent to an interaction.
+type ResponseType uint32
+
+const (
+	// ResponseTypeNone means no response has been sent to the interaction yet.
+	ResponseTypeNone ResponseType = iota
+	// ResponseTypeMessage means the interaction was responded to with a message.
+	ResponseTypeMessage
+	// ResponseTypeDeferred means the response to the interaction was deferred, either by the executor or by the
+	// handler.
+	ResponseTypeDeferred
+)
+
+// DoubleResponsePolicy decides what happens when Interaction.Respond or Interaction.Defer is called on an interaction
+// that has already been responded to. It can be set using Handler.WithDoubleResponsePolicy.
+type DoubleResponsePolicy uint8
+
+const (
+	// DoubleResponseError makes responses fail with ErrAlreadyResponded. This is the default policy.
+	DoubleResponseError DoubleResponsePolicy = iota
+	// DoubleResponseEditDeferred makes a response replace the loading state of a deferred response by editing it. If
+	// the response was not deferred or the loading state has already been replaced, ErrAlreadyResponded is returned.
+	DoubleResponseEditDeferred
+	// DoubleResponseFollowup makes responses replace the loading state of a deferred response, or be sent as a followup
+	// message otherwise.
+	DoubleResponseFollowup
+)
+
 // API returns the underlying discord API used. The command executor can use this to perform additional actions not
 // supported by the interaction instance itself.
 func (i *Interaction) API() API {
 	return i.api
 }
 
+// Logger returns the Logger of the handler that received the interaction. All messages logged are prefixed with the
+// command path, interaction ID, user and guild of the interaction.
+func (i *Interaction) Logger() Logger {
+	return i.logger
+}
+
+// Context returns the context of the interaction. Its deadline is the moment the interaction token expires, 15 minutes
+// after the interaction was created, after which no more followup messages can be sent. It is also cancelled when the
+// handler is shut down. Use InitialResponseDeadline to get the deadline for the initial response to the interaction.
+func (i *Interaction) Context() context.Context {
+	return i.ctx
+}
+
+// Responded returns whether a response has been sent to the interaction.
+func (i *Interaction) Responded() bool {
+	return i.ResponseType() != ResponseTypeNone
+}
+
+// ResponseType returns the type of the response sent to the interaction, or ResponseTypeNone if there is none.
+func (i *Interaction) ResponseType() ResponseType {
+	return ResponseType(i.responseType.Load())
+}
+
 // Respond sends a MessageResponse, which is a normal message response. Multiple message responses can be sent to the
-// interaction. When the response has been sent, the message will also be returned and can be edited or deleted.
+// interaction. When the response has been sent, the message will also be returned and can be edited or deleted. If a
+// response has already been sent, the DoubleResponsePolicy of the handler decides what happens. If the handler deferred
+// the response because the executor took too long to respond, the deferred response is edited into the message instead.
+// An ephemeral message never replaces a deferred response that is not ephemeral, but is sent as followup message after
+// which the deferred response is deleted.
 func (i *Interaction) Respond(response MessageResponse) (*Followup, error) {
-	if !i.hasResponded.CAS(false, true) {
-		panic("cannot send multiple responses to the same interaction")
+	i.respondMu.Lock()
+	defer i.respondMu.Unlock()
+
+	if i.Responded() {
+		return i.respondAgain(response)
 	}
 
 	err := i.api.RespondInteraction(i.interactionId, i.interactionToken, response.marshal())
 	if err != nil {
 		return nil, err
 	}
+	i.responseType.Store(uint32(ResponseTypeMessage))
 
 	// Create and return a *cmd.Followup, which can be used to send followup responses to the interaction.
-	return &Followup{
-		api:              i.api,
-		appId:            i.appId,
-		interactionToken: i.interactionToken,
-	}, nil
+	return i.followup(), nil
+}
+
+// respondAgain handles a response sent to an interaction that was already responded to, according to the
+// DoubleResponsePolicy of the interaction.
+func (i *Interaction) respondAgain(response MessageResponse) (*Followup, error) {
+	// The executor does not know about a response deferred by the handler, so it may always replace it.
+	replace := i.autoDeferred.CAS(true, false)
+	if !replace {
+		switch i.policy {
+		case DoubleResponseEditDeferred:
+			replace = i.deferPending.Load()
+		case DoubleResponseFollowup:
+			replace = true
+		}
+	}
+	if !replace {
+		return nil, ErrAlreadyResponded
+	}
+
+	// Creating a message with the followup replaces the loading state if the response was deferred.
+	f := i.followup()
+	if _, err := f.Create(response); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // Response returns the message sent to the interaction as response. This assumes that the response sent to the discord
 // api was a message response, and not a deferred message response.
 func (i *Interaction) Response() (*discord.Message, error) {
-	if !i.hasResponded.Load() {
+	if !i.Responded() {
 		return nil, errors.New("an interaction response has not yet been created")
 	}
+	if i.expired() {
+		return nil, ErrInteractionExpired
+	}
 
 	return i.api.InteractionResponse(i.appId, i.interactionToken)
 }
@@ -66,9 +168,12 @@ func (i *Interaction) Response() (*discord.Message, error) {
 // and not a deferred response. The message also still needs to exist in order for this to work.
 // todo: do not expose arikawa type?
 func (i *Interaction) EditResponse(editedResponse api.EditInteractionResponseData) (*discord.Message, error) {
-	if !i.hasResponded.Load() {
+	if !i.Responded() {
 		return nil, errors.New("an interaction response has not yet been created")
 	}
+	if i.expired() {
+		return nil, ErrInteractionExpired
+	}
 
 	return i.api.EditInteractionResponse(i.appId, i.interactionToken, editedResponse)
 }
@@ -76,9 +181,12 @@ func (i *Interaction) EditResponse(editedResponse api.EditInteractionResponseDat
 // DeleteResponse will delete the original response to the command. This can naturally only be done once and if a
 // message response has been sent previously.
 func (i *Interaction) DeleteResponse() error {
-	if !i.hasResponded.Load() {
+	if !i.Responded() {
 		return errors.New("an interaction response has not yet been created")
 	}
+	if i.expired() {
+		return ErrInteractionExpired
+	}
 
 	return i.api.DeleteInteractionResponse(i.appId, i.interactionToken)
 }
@@ -86,25 +194,75 @@ func (i *Interaction) DeleteResponse() error {
 // DeferResponse sends an api.DeferredMessageInteractionWithSource to discord, which is a response that acknowledges
 // that the command has been received by the bot and a message response will be sent later. The user will keep seeing
 // the loading state of the command until that message gets sent. Sending this will allow more time than the standard
-// short period you have after a command is sent. DeferResponse must not be called after the first time it is called.
+// short period you have after a command is sent. If a response has already been sent, ErrAlreadyResponded is returned,
+// unless it was a response deferred by the handler itself or the DoubleResponsePolicy of the handler allows it. It is
+// equal to calling Interaction.Defer with an empty DeferredResponse.
 func (i *Interaction) DeferResponse() (*Followup, error) {
-	if !i.hasResponded.CAS(false, true) {
-		panic("cannot send multiple responses to the same interaction")
+	return i.Defer(DeferredResponse{})
+}
+
+// Defer defers the response to the interaction with the options provided. The first message created using the
+// *cmd.Followup returned will replace the loading state of the interaction. Other than that, it is the same as
+// Interaction.DeferResponse.
+func (i *Interaction) Defer(response DeferredResponse) (*Followup, error) {
+	i.respondMu.Lock()
+	defer i.respondMu.Unlock()
+
+	if i.Responded() {
+		// Deferring again would not do anything, so the followup of the existing response can be used.
+		if !i.autoDeferred.Load() && i.policy == DoubleResponseError {
+			return nil, ErrAlreadyResponded
+		}
+		return i.followup(), nil
 	}
 
-	err := i.api.RespondInteraction(i.interactionId, i.interactionToken, api.InteractionResponse{
-		Type: api.DeferredMessageInteractionWithSource,
-	})
+	err := i.api.RespondInteraction(i.interactionId, i.interactionToken, response.marshal())
 	if err != nil {
 		return nil, err
 	}
+	i.responseType.Store(uint32(ResponseTypeDeferred))
+	i.deferEphemeral.Store(response.Ephemeral)
+	i.deferPending.Store(true)
 
 	// Create and return a *cmd.Followup, which can be used to send followup responses to the interaction.
+	return i.followup(), nil
+}
+
+// autoDefer defers the response to the interaction if no response has been sent yet. It is called by the handler when
+// the executor takes too long to respond. A later call to Respond will edit the deferred response.
+func (i *Interaction) autoDefer(ephemeral bool) error {
+	i.respondMu.Lock()
+	defer i.respondMu.Unlock()
+
+	if i.Responded() {
+		return nil
+	}
+	resp := DeferredResponse{Ephemeral: ephemeral}
+	if err := i.api.RespondInteraction(i.interactionId, i.interactionToken, resp.marshal()); err != nil {
+		return err
+	}
+	i.responseType.Store(uint32(ResponseTypeDeferred))
+	i.deferEphemeral.Store(ephemeral)
+	i.autoDeferred.Store(true)
+	i.deferPending.Store(true)
+	return nil
+}
+
+// followup returns a new *cmd.Followup for the interaction.
+func (i *Interaction) followup() *Followup {
 	return &Followup{
 		api:              i.api,
 		appId:            i.appId,
 		interactionToken: i.interactionToken,
-	}, nil
+		expires:          i.expires,
+		deferPending:     &i.deferPending,
+		deferEphemeral:   &i.deferEphemeral,
+	}
+}
+
+// expired returns whether the token of the interaction has expired.
+func (i *Interaction) expired() bool {
+	return !time.Now().Before(i.expires)
 }
 
 // User returns the *discord.User who executed the command.
@@ -29,3 +29,47 @@ func (NopLogger) Println(args ...interface{}) {}
 func (NopLogger) Warnln(args ...interface{})  {}
 func (NopLogger) Errorln(args ...interface{}) {}
 func (NopLogger) Fatalln(args ...interface{}) {}
+
+// prefixLogger is a Logger that prefixes all messages with a fixed string before passing them on to its parent. It is
+// used to add context about an interaction to all messages logged while handling it.
+type prefixLogger struct {
+	parent Logger
+	prefix string
+}
+
+// newPrefixLogger returns a Logger that prefixes all messages logged with the prefix provided.
+func newPrefixLogger(parent Logger, prefix string) Logger {
+	return prefixLogger{parent: parent, prefix: prefix}
+}
+
+func (l prefixLogger) Debugf(format string, args ...interface{}) {
+	l.parent.Debugf("%s "+format, append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Printf(format string, args ...interface{}) {
+	l.parent.Printf("%s "+format, append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Warnf(format string, args ...interface{}) {
+	l.parent.Warnf("%s "+format, append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Errorf(format string, args ...interface{}) {
+	l.parent.Errorf("%s "+format, append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Fatalf(format string, args ...interface{}) {
+	l.parent.Fatalf("%s "+format, append([]interface{}{l.prefix}, args...)...)
+}
+
+func (l prefixLogger) Debugln(args ...interface{}) {
+	l.parent.Debugln(append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Println(args ...interface{}) {
+	l.parent.Println(append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Warnln(args ...interface{}) {
+	l.parent.Warnln(append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Errorln(args ...interface{}) {
+	l.parent.Errorln(append([]interface{}{l.prefix}, args...)...)
+}
+func (l prefixLogger) Fatalln(args ...interface{}) {
+	l.parent.Fatalln(append([]interface{}{l.prefix}, args...)...)
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// CommandInfo describes a command of a Handler, as returned by Handler.Commands.
+type CommandInfo struct {
+	// Name is the name of the command.
+	Name string
+	// ID is the ID of the command registered to discord. It is 0 if the command is not registered.
+	ID discord.CommandID
+	// Guild is the guild the command is registered in, or discord.NullGuildID if it is a global command or not
+	// registered.
+	Guild discord.GuildID
+	// Scope contains the guilds the command is registered in by Handler.Sync, as set using Command.WithGuilds.
+	Scope []discord.GuildID
+	// Registered is true if the command is registered to discord, and false if it is pending.
+	Registered bool
+	// Subcommands contains the subcommands and subcommand groups of the command, in the order in which they show up in
+	// discord.
+	Subcommands []SubcommandInfo
+}
+
+// SubcommandInfo describes a subcommand or subcommand group of a command, as returned by Handler.Commands.
+type SubcommandInfo struct {
+	// Name is the name of the subcommand or subcommand group.
+	Name string
+	// Description is the description of the subcommand or subcommand group.
+	Description string
+	// Subcommands contains the subcommands within the subcommand group. It is empty for subcommands.
+	Subcommands []SubcommandInfo
+}
+
+// Commands returns all commands of the handler, both registered and pending. A command registered in multiple scopes
+// is returned once for every scope. Registered commands are returned first, sorted by guild and name, followed by the
+// pending commands sorted by name.
+func (h *Handler) Commands() []CommandInfo {
+	h.commandsMu.RLock()
+	defer h.commandsMu.RUnlock()
+
+	var registered, pending []CommandInfo
+	for id, cmd := range h.commands {
+		info := commandInfo(cmd)
+		info.ID, info.Guild, info.Registered = id, cmd.guild, true
+		registered = append(registered, info)
+	}
+	for _, cmd := range h.pendingCommands {
+		pending = append(pending, commandInfo(cmd))
+	}
+	sort.Slice(registered, func(i, j int) bool {
+		if registered[i].Guild != registered[j].Guild {
+			return registered[i].Guild < registered[j].Guild
+		}
+		return registered[i].Name < registered[j].Name
+	})
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Name < pending[j].Name
+	})
+	return append(registered, pending...)
+}
+
+// commandInfo returns the CommandInfo of the command provided, without any registration details.
+func commandInfo(cmd Command) CommandInfo {
+	info := CommandInfo{Name: cmd.name, Guild: discord.NullGuildID, Scope: cmd.Scope()}
+	top, groups := cmd.subcommandLayout()
+	for _, name := range top {
+		if sub, ok := cmd.subcommands[name]; ok {
+			info.Subcommands = append(info.Subcommands, SubcommandInfo{Name: sub.name, Description: sub.description})
+			continue
+		}
+		group := SubcommandInfo{Name: name, Description: cmd.subGroups[name]}
+		for _, fullName := range groups[name] {
+			sub := cmd.subcommands[fullName]
+			group.Subcommands = append(group.Subcommands, SubcommandInfo{Name: sub.name, Description: sub.description})
+		}
+		info.Subcommands = append(info.Subcommands, group)
+	}
+	return info
+}
+
+// Remove removes the command with the name provided from the guild provided, or from the global commands if
+// discord.NullGuildID is provided. If the command is registered, it is deleted from discord and no longer handled. If it
+// is pending, it will not be registered in the guild. ErrUnknownCommand is returned if the handler has no such command.
+func (h *Handler) Remove(discordAPI API, name string, guildId discord.GuildID) error {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+
+	guildId = h.target(guildId)
+	found := false
+	if cmd, ok := h.pendingCommands[name]; ok && (h.devGuild.IsValid() || cmd.inScope(guildId)) {
+		found = true
+		if len(cmd.scope) > 1 {
+			// The command is still registered in the other guilds of its scope.
+			cmd.scope = removeGuild(cmd.scope, guildId)
+			h.setPending(cmd)
+		} else {
+			delete(h.pendingCommands, name)
+		}
+	}
+
+	ids := h.registeredIDs(name, guildId)
+	if len(ids) == 0 {
+		if !found {
+			return fmt.Errorf("%w: /%s %s", ErrUnknownCommand, name, scopeName(guildId))
+		}
+		return nil
+	}
+	app, err := discordAPI.CurrentApplication()
+	if err != nil {
+		return err
+	}
+	h.invalidateRegistration(guildId)
+	for _, id := range ids {
+		if err = deleteCommand(discordAPI, app.ID, guildId, id); err != nil {
+			h.logger.Errorf("Failed to remove command /%s %s: %v", name, scopeName(guildId), err)
+			return err
+		}
+		delete(h.commands, id)
+	}
+	h.logger.Printf("Removed command /%s %s", name, scopeName(guildId))
+	return nil
+}
+
+// Replace replaces the command with the same name as the command provided in the guild provided, or the global command
+// if discord.NullGuildID is provided. A pending command is replaced before it is registered. A registered command is
+// edited on discord, after which interactions are handled by the new command. If the handler has no such command, the
+// command is registered in the guild directly.
+func (h *Handler) Replace(discordAPI API, cmd Command, guildId discord.GuildID) error {
+	h.registerMu.Lock()
+	defer h.registerMu.Unlock()
+	h.commandsMu.Lock()
+	defer h.commandsMu.Unlock()
+
+	if h.devGuild.IsValid() {
+		cmd.prefix = h.devPrefix
+	}
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+	guildId = h.target(guildId)
+	_, pending := h.pendingCommands[cmd.name]
+	if pending {
+		h.setPending(cmd)
+	}
+	ids := h.registeredIDs(cmd.name, guildId)
+	if pending && len(ids) == 0 {
+		return nil
+	}
+
+	app, err := discordAPI.CurrentApplication()
+	if err != nil {
+		return err
+	}
+	h.invalidateRegistration(guildId)
+	if len(ids) == 0 {
+		registered, err := createCommand(discordAPI, app.ID, guildId, cmd.marshal())
+		if err != nil {
+			h.logger.Errorf("Failed to register command /%s %s: %v", cmd.name, scopeName(guildId), err)
+			return err
+		}
+		ids = []discord.CommandID{registered.ID}
+	} else {
+		for _, id := range ids {
+			if _, err = editCommand(discordAPI, app.ID, guildId, id, cmd.marshal()); err != nil {
+				h.logger.Errorf("Failed to replace command /%s %s: %v", cmd.name, scopeName(guildId), err)
+				return err
+			}
+		}
+	}
+
+	cmd.guild = guildId
+	cmd.registered = true
+	for _, id := range ids {
+		h.commands[id] = cmd
+	}
+	h.logger.Printf("Replaced command /%s %s", cmd.name, scopeName(guildId))
+	return nil
+}
+
+// registeredIDs returns the IDs bound to the registered command with the name provided in the guild provided.
+// h.commandsMu must be held while calling registeredIDs.
+func (h *Handler) registeredIDs(name string, guildId discord.GuildID) (ids []discord.CommandID) {
+	for id, cmd := range h.commands {
+		if cmd.name == name && cmd.guild == guildId {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// invalidateRegistration clears the Registration stored in the HashStore of the handler for the guild provided, before
+// the commands registered in it are changed individually.
+func (h *Handler) invalidateRegistration(guildId discord.GuildID) {
+	if h.hashes == nil {
+		return
+	}
+	if err := h.hashes.SetRegistration(guildId, Registration{}); err != nil {
+		h.logger.Warnf("Failed to clear registration of commands %s: %v", scopeName(guildId), err)
+	}
+}
+
+// removeGuild returns the guilds provided without the guild to remove.
+func removeGuild(guilds []discord.GuildID, remove discord.GuildID) []discord.GuildID {
+	var kept []discord.GuildID
+	for _, id := range guilds {
+		if id != remove {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
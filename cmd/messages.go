@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// Messages contains the messages the Handler responds with when it refuses to execute a command or a command fails. They
+// are always sent as ephemeral responses. The messages can be changed using Handler.WithMessages, for example to
+// translate them.
+type Messages struct {
+	// DMNotAllowed is sent when a command that is not available in direct messages is executed in one.
+	DMNotAllowed string
+	// NSFWChannel is sent when an age-restricted command is executed in a channel that is not marked as NSFW.
+	NSFWChannel string
+	// DMOnly is sent when an executor embedding DMOnly is executed in a guild.
+	DMOnly string
+	// OwnerOnly is sent when an executor embedding OwnerOnly is executed by someone other than the owner of the
+	// application.
+	OwnerOnly string
+	// InternalError is sent by Handler.RespondError when a command fails with an error that is not a UserError.
+	InternalError string
+	// MissingPermissions returns the message sent when the member executing an executor implementing
+	// PermissionsRequirer is missing some of the required permissions.
+	MissingPermissions func(missing discord.Permissions) string
+	// MissingBotPermissions returns the message sent when the bot is missing some of the permissions required by an
+	// executor implementing BotPermissionsRequirer.
+	MissingBotPermissions func(missing discord.Permissions) string
+	// PermissionsUnknown is sent when the permissions required by an executor could not be checked, for example
+	// because the permissions of the member or the bot could not be found in the state.
+	PermissionsUnknown string
+	// Cooldown returns the message sent when a command is executed while it is on cooldown.
+	Cooldown func(retryAfter time.Duration) string
+	// ConcurrencyLimit is sent when a command is executed while the maximum amount of concurrent executions of the
+	// command, or of all commands as set using Handler.WithMaxConcurrency, has been reached.
+	ConcurrencyLimit string
+	// Busy is sent when all workers of the handler are busy and no more commands can be queued.
+	Busy string
+	// Restarting is sent when a command is executed while the handler is shutting down. If it is empty, which it is by
+	// default, these interactions are not responded to at all.
+	Restarting string
+}
+
+// DefaultMessages are the messages used by a Handler if no other messages have been set.
+var DefaultMessages = Messages{
+	DMNotAllowed:  "This command cannot be used in direct messages.",
+	NSFWChannel:   "This command can only be used in channels marked as NSFW.",
+	DMOnly:        "This command can only be used in direct messages.",
+	OwnerOnly:     "This command can only be used by the owner of the bot.",
+	InternalError: "Something went wrong while executing this command.",
+	MissingPermissions: func(missing discord.Permissions) string {
+		return "You need the following permissions to use this command: " + strings.Join(PermissionNames(missing), ", ")
+	},
+	MissingBotPermissions: func(missing discord.Permissions) string {
+		return "I need the following permissions to run this command: " + strings.Join(PermissionNames(missing), ", ")
+	},
+	PermissionsUnknown: "Your permissions could not be checked, try again later.",
+	Cooldown: func(retryAfter time.Duration) string {
+		return fmt.Sprintf("This command is on cooldown, try again in %v.", (retryAfter + time.Second - 1).Truncate(time.Second))
+	},
+	ConcurrencyLimit: "This command is being used too much right now, try again later.",
+	Busy:             "The bot is busy right now, try again later.",
+}
+
+// withDefaults returns the messages with every empty message and nil function replaced by the one in DefaultMessages,
+// as Discord does not accept empty responses. Restarting is left empty, as it disables the response.
+func (m Messages) withDefaults() Messages {
+	for _, s := range []struct{ msg, def *string }{
+		{&m.DMNotAllowed, &DefaultMessages.DMNotAllowed},
+		{&m.NSFWChannel, &DefaultMessages.NSFWChannel},
+		{&m.DMOnly, &DefaultMessages.DMOnly},
+		{&m.OwnerOnly, &DefaultMessages.OwnerOnly},
+		{&m.InternalError, &DefaultMessages.InternalError},
+		{&m.PermissionsUnknown, &DefaultMessages.PermissionsUnknown},
+		{&m.ConcurrencyLimit, &DefaultMessages.ConcurrencyLimit},
+		{&m.Busy, &DefaultMessages.Busy},
+	} {
+		if *s.msg == "" {
+			*s.msg = *s.def
+		}
+	}
+	if m.MissingPermissions == nil {
+		m.MissingPermissions = DefaultMessages.MissingPermissions
+	}
+	if m.MissingBotPermissions == nil {
+		m.MissingBotPermissions = DefaultMessages.MissingBotPermissions
+	}
+	if m.Cooldown == nil {
+		m.Cooldown = DefaultMessages.Cooldown
+	}
+	return m
+}
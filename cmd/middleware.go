@@ -0,0 +1,53 @@
+package cmd
+
+import "time"
+
+// Execution contains the details of a command that is about to be executed. It is passed through all middleware before
+// the executor is ran.
+type Execution struct {
+	// Path is the full name of the command executed, including the subcommand group and subcommand if applicable:
+	// "command", "command subcommand" or "command group subcommand".
+	Path string
+	// Command is the command that was executed.
+	Command Command
+	// Executor is the Executor or ErrorExecutor that will be ran, with all parameters already set.
+	Executor any
+	// Interaction is the interaction that will be passed to the executor.
+	Interaction *Interaction
+	// QueueWait is the time the execution spent waiting for a worker of the handler. It is always 0 if the handler
+	// does not use workers.
+	QueueWait time.Duration
+}
+
+// ExecuteFunc executes a command. The last ExecuteFunc in a chain of middleware runs the executor of the Execution. Any
+// error returned is passed to the error handler of the Handler.
+type ExecuteFunc func(e Execution) error
+
+// Middleware wraps the execution of a command. It can run code before and after the command by calling next, change the
+// Execution passed to next, or prevent the command from being executed at all by not calling next. Errors returned by
+// next can be inspected, replaced or passed on, and middleware can return its own errors. Middleware can be
+// added to a Handler using Handler.Use, to a Command using Command.WithMiddleware and to a subcommand group using
+// Command.WithGroupMiddleware.
+type Middleware func(next ExecuteFunc) ExecuteFunc
+
+// chain wraps the ExecuteFunc in the middleware provided. The first middleware will be the outermost one, and thus be
+// the first to run.
+func chain(f ExecuteFunc, middleware ...[]Middleware) ExecuteFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		for j := len(middleware[i]) - 1; j >= 0; j-- {
+			f = middleware[i][j](f)
+		}
+	}
+	return f
+}
+
+// run is the ExecuteFunc at the end of every chain of middleware, and runs the executor.
+func run(e Execution) error {
+	switch executor := e.Executor.(type) {
+	case ErrorExecutor:
+		return executor.Run(e.Interaction)
+	case Executor:
+		executor.Run(e.Interaction)
+	}
+	return nil
+}
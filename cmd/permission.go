@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// PermissionsRequirer is an interface that can be implemented by a command executor to require the member executing it
+// to have certain permissions in the channel it is executed in. Unlike the default member permissions of a command,
+// this can differ for every subcommand. The permissions are checked right before the executor is ran, and the
+// executor will never be ran in direct messages. Checking permissions requires the API passed to the handler to keep
+// track of the state of guilds, such as *state.State.
+type PermissionsRequirer interface {
+	// RequiredPermissions returns the permissions the member needs to have to execute the executor.
+	RequiredPermissions() discord.Permissions
+}
+
+// BotPermissionsRequirer is an interface that can be implemented by a command executor to require the bot to have
+// certain permissions in the channel the executor is executed in. It is checked in the same way as
+// PermissionsRequirer.
+type BotPermissionsRequirer interface {
+	// RequiredBotPermissions returns the permissions the bot needs to have to execute the executor.
+	RequiredBotPermissions() discord.Permissions
+}
+
+// permissionNames contains the names of all permissions, in the order they are shown in the discord client.
+var permissionNames = []struct {
+	perm discord.Permissions
+	name string
+}{
+	{discord.PermissionAdministrator, "Administrator"},
+	{discord.PermissionViewChannel, "View Channels"},
+	{discord.PermissionManageChannels, "Manage Channels"},
+	{discord.PermissionManageRoles, "Manage Roles"},
+	{discord.PermissionManageEmojisAndStickers, "Manage Emojis and Stickers"},
+	{discord.PermissionViewAuditLog, "View Audit Log"},
+	{discord.PermissionManageWebhooks, "Manage Webhooks"},
+	{discord.PermissionManageGuild, "Manage Server"},
+	{discord.PermissionCreateInstantInvite, "Create Invite"},
+	{discord.PermissionChangeNickname, "Change Nickname"},
+	{discord.PermissionManageNicknames, "Manage Nicknames"},
+	{discord.PermissionKickMembers, "Kick Members"},
+	{discord.PermissionBanMembers, "Ban Members"},
+	{discord.PermissionModerateMembers, "Timeout Members"},
+	{discord.PermissionSendMessages, "Send Messages"},
+	{discord.PermissionSendMessagesInThreads, "Send Messages in Threads"},
+	{discord.PermissionCreatePublicThreads, "Create Public Threads"},
+	{discord.PermissionCreatePrivateThreads, "Create Private Threads"},
+	{discord.PermissionEmbedLinks, "Embed Links"},
+	{discord.PermissionAttachFiles, "Attach Files"},
+	{discord.PermissionAddReactions, "Add Reactions"},
+	{discord.PermissionUseExternalEmojis, "Use External Emoji"},
+	{discord.PermissionUseExternalStickers, "Use External Stickers"},
+	{discord.PermissionMentionEveryone, "Mention Everyone"},
+	{discord.PermissionManageMessages, "Manage Messages"},
+	{discord.PermissionManageThreads, "Manage Threads"},
+	{discord.PermissionReadMessageHistory, "Read Message History"},
+	{discord.PermissionSendTTSMessages, "Send Text-to-Speech Messages"},
+	{discord.PermissionUseSlashCommands, "Use Application Commands"},
+	{discord.PermissionConnect, "Connect"},
+	{discord.PermissionSpeak, "Speak"},
+	{discord.PermissionStream, "Video"},
+	{discord.PermissionStartEmbeddedActivities, "Use Activities"},
+	{discord.PermissionUseVAD, "Use Voice Activity"},
+	{discord.PermissionPrioritySpeaker, "Priority Speaker"},
+	{discord.PermissionMuteMembers, "Mute Members"},
+	{discord.PermissionDeafenMembers, "Deafen Members"},
+	{discord.PermissionMoveMembers, "Move Members"},
+	{discord.PermissionRequestToSpeak, "Request to Speak"},
+}
+
+// PermissionNames returns the english names of all permissions in the discord.Permissions provided, as they are shown
+// in the discord client.
+func PermissionNames(perms discord.Permissions) (names []string) {
+	for _, p := range permissionNames {
+		if perms&p.perm != 0 {
+			names = append(names, p.name)
+		}
+	}
+	return
+}
+
+// missingPermissions returns the permissions in required that are not in perms. Administrators are never missing any
+// permissions.
+func missingPermissions(perms, required discord.Permissions) discord.Permissions {
+	if perms.Has(discord.PermissionAdministrator) {
+		return 0
+	}
+	return required &^ perms
+}
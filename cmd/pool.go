@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// BusyPolicy decides what happens to an interaction when all workers of the handler are busy and its queue is full.
+type BusyPolicy uint8
+
+const (
+	// BusyReject responds to the interaction with Messages.Busy, without executing the command.
+	BusyReject BusyPolicy = iota
+	// BusyDefer defers the response to the interaction, and waits until there is room in the queue. The command is not
+	// executed if the interaction token expires before that. Waiting is done on a separate goroutine, so that other
+	// gateway events are still handled in the meantime.
+	BusyDefer
+)
+
+// PoolStats contains statistics about the workers of a Handler, as returned by Handler.PoolStats.
+type PoolStats struct {
+	// Workers is the amount of workers executing commands.
+	Workers int
+	// Busy is the amount of workers currently executing a command.
+	Busy int
+	// Queued is the amount of commands waiting in the queue to be executed.
+	Queued int
+	// QueueSize is the maximum amount of commands that can wait in the queue.
+	QueueSize int
+	// Executed is the total amount of commands executed by the workers.
+	Executed uint64
+	// Rejected is the total amount of commands that could not be queued, because the queue was full.
+	Rejected uint64
+	// TotalWait is the total time commands executed by the workers spent waiting in the queue. Divide it by Executed to
+	// get the average time spent waiting.
+	TotalWait time.Duration
+}
+
+// job is a function queued to be ran by a workerPool. It is passed the time it spent waiting in the queue.
+type job struct {
+	f      func(wait time.Duration)
+	queued time.Time
+}
+
+// workerPool runs jobs on a fixed amount of goroutines, with a bounded queue of jobs waiting to be ran.
+type workerPool struct {
+	workers int
+	jobs    chan job
+	done    chan struct{}
+
+	busy      atomic.Int32
+	executed  atomic.Uint64
+	rejected  atomic.Uint64
+	totalWait atomic.Duration
+}
+
+// newWorkerPool creates a new workerPool and starts its workers.
+func newWorkerPool(workers, queueSize int) *workerPool {
+	p := &workerPool{
+		workers: workers,
+		jobs:    make(chan job, queueSize),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work runs jobs from the queue until the pool is stopped.
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case j := <-p.jobs:
+			wait := time.Since(j.queued)
+
+			p.busy.Inc()
+			j.f(wait)
+			p.busy.Dec()
+
+			p.executed.Inc()
+			p.totalWait.Add(wait)
+		}
+	}
+}
+
+// trySubmit queues the function provided if there is room in the queue, and returns false if there is not.
+func (p *workerPool) trySubmit(f func(wait time.Duration)) bool {
+	select {
+	case p.jobs <- job{f: f, queued: time.Now()}:
+		return true
+	default:
+		p.rejected.Inc()
+		return false
+	}
+}
+
+// submit queues the function provided, waiting for room in the queue until the context is done.
+func (p *workerPool) submit(ctx context.Context, f func(wait time.Duration)) error {
+	select {
+	case p.jobs <- job{f: f, queued: time.Now()}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats returns the current PoolStats of the pool.
+func (p *workerPool) stats() PoolStats {
+	return PoolStats{
+		Workers:   p.workers,
+		Busy:      int(p.busy.Load()),
+		Queued:    len(p.jobs),
+		QueueSize: cap(p.jobs),
+		Executed:  p.executed.Load(),
+		Rejected:  p.rejected.Load(),
+		TotalWait: p.totalWait.Load(),
+	}
+}
+
+// stop stops all workers once they have finished the job they are running. Jobs still in the queue are not ran.
+func (p *workerPool) stop() {
+	close(p.done)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(1, 1)
+	defer p.stop()
+
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	waits := make(chan time.Duration, 2)
+	blocking := func(wait time.Duration) {
+		close(started)
+		<-unblock
+		waits <- wait
+	}
+	queued := func(wait time.Duration) {
+		waits <- wait
+	}
+
+	if !p.trySubmit(blocking) {
+		t.Fatal("trySubmit() = false with an empty queue")
+	}
+	<-started
+	if !p.trySubmit(queued) {
+		t.Fatal("trySubmit() = false with room in the queue")
+	}
+	if p.trySubmit(queued) {
+		t.Fatal("trySubmit() = true with a full queue")
+	}
+	want := PoolStats{Workers: 1, Busy: 1, Queued: 1, QueueSize: 1, Rejected: 1}
+	if got := p.stats(); got != want {
+		t.Errorf("stats() = %+v, want %+v", got, want)
+	}
+
+	// Waiting for room in the full queue stops once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.submit(ctx, queued); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("submit() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	<-waits
+	if wait := <-waits; wait < 10*time.Millisecond {
+		t.Errorf("queued job waited %v, want at least 10ms", wait)
+	}
+	waitFor(t, "jobs to finish", func() bool { return p.stats().Executed == 2 })
+	stats := p.stats()
+	if stats.Busy != 0 || stats.Queued != 0 || stats.Rejected != 1 {
+		t.Errorf("stats() = %+v, want no busy workers or queued jobs and 1 rejected job", stats)
+	}
+	if stats.TotalWait < 10*time.Millisecond {
+		t.Errorf("stats().TotalWait = %v, want at least 10ms", stats.TotalWait)
+	}
+
+	if err := p.submit(context.Background(), queued); err != nil {
+		t.Errorf("submit() = %v with room in the queue", err)
+	}
+	<-waits
+}
@@ -36,6 +36,14 @@ type MessageResponse struct {
 	TTS bool
 }
 
+// DeferredResponse contains the options for deferring the response to an interaction using Interaction.Defer. The user
+// will see a loading state until the response is sent using the *cmd.Followup returned.
+type DeferredResponse struct {
+	// Ephemeral decides whether the response that will be sent later will only be visible to the user. This cannot be
+	// changed after deferring the response.
+	Ephemeral bool
+}
+
 // ModalResponse is a command response that sends a form to the user that runs this command. This is currently not a
 // publicly available option.
 // todo: work on this when forms become public
@@ -67,3 +75,33 @@ func (m MessageResponse) marshal() (r api.InteractionResponse) {
 	}
 	return
 }
+
+// editData returns the message as api.EditInteractionResponseData, to replace a deferred response with the message. The
+// message cannot be made ephemeral this way, as that is decided when deferring the response.
+func (m MessageResponse) editData() (d api.EditInteractionResponseData) {
+	if m.Content == "" && len(m.Embeds) == 0 && len(m.Files) == 0 {
+		panic("Can't send an empty message response")
+	}
+	d = api.EditInteractionResponseData{
+		Files:           m.Files,
+		AllowedMentions: m.AllowedMentions,
+	}
+	if m.Content != "" {
+		d.Content = option.NewNullableString(m.Content)
+	}
+	if len(m.Embeds) > 0 {
+		d.Embeds = &m.Embeds
+	}
+	if len(m.Components) > 0 {
+		d.Components = &m.Components
+	}
+	return
+}
+
+func (d DeferredResponse) marshal() (r api.InteractionResponse) {
+	r = api.InteractionResponse{Type: api.DeferredMessageInteractionWithSource}
+	if d.Ephemeral {
+		r.Data = &api.InteractionResponseData{Flags: api.EphemeralResponse}
+	}
+	return
+}
@@ -4,7 +4,9 @@ package cmd
 type Subcommand struct {
 	name, group, description string
 
-	executor Executor
+	executor any // Executor or ErrorExecutor
+	cooldown *Cooldown
+	source   string
 }
 
 // Name is the name of the subcommand, and what the user will have to type to execute it. To execute it, you will need
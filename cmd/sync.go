@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// SyncOptions contains the options for synchronising the commands of a Handler with the commands registered to discord
+// using Handler.Sync, Handler.SyncAll or Handler.SyncAllGuild.
+type SyncOptions struct {
+	// DryRun only determines the changes that need to be made, without making them.
+	DryRun bool
+	// KeepUnknown keeps commands registered to discord that the handler does not have, instead of deleting them.
+	KeepUnknown bool
+}
+
+// SyncPlan describes the changes needed to make the commands registered to discord in a single scope match the commands
+// of a Handler. All commands are referred to by name.
+type SyncPlan struct {
+	// Guild is the guild the commands are registered in, or discord.NullGuildID for global commands.
+	Guild discord.GuildID
+	// Create contains the commands that are not registered yet.
+	Create []string
+	// Update contains the commands that are registered, but differ from the commands of the handler.
+	Update []string
+	// Delete contains the registered commands the handler does not have.
+	Delete []string
+	// Keep contains the registered commands that the handler does not have, but are kept because of
+	// SyncOptions.KeepUnknown.
+	Keep []string
+	// Unchanged contains the commands that are registered and equal to the commands of the handler.
+	Unchanged []string
+
+	create []Command
+	update map[discord.CommandID]Command
+	same   map[discord.CommandID]Command
+	delete []discord.CommandID
+}
+
+// Empty returns whether the plan does not contain any changes.
+func (p SyncPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// String returns a human-readable summary of the plan, which can be used to review it before applying it.
+func (p SyncPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Command sync %s:", scopeName(p.Guild))
+	for _, change := range []struct {
+		prefix string
+		names  []string
+	}{{"+", p.Create}, {"~", p.Update}, {"-", p.Delete}, {"=", p.Keep}} {
+		for _, name := range change.names {
+			fmt.Fprintf(&b, "\n  %s /%s", change.prefix, name)
+		}
+	}
+	if p.Empty() {
+		b.WriteString(" no changes")
+	}
+	return b.String()
+}
+
+// remoteCommand is a command registered to discord, together with its normalised form to compare it with the commands
+// of a Handler.
+type remoteCommand struct {
+	discord.Command
+	normalised any
+}
+
+// planSync determines the changes needed to make the remote commands match the local commands provided.
+func planSync(guildId discord.GuildID, local map[string]Command, remote []remoteCommand, opts SyncOptions, raw bool) (SyncPlan, error) {
+	plan := SyncPlan{
+		Guild:  guildId,
+		update: map[discord.CommandID]Command{},
+		same:   map[discord.CommandID]Command{},
+	}
+
+	seen := map[string]struct{}{}
+	for _, r := range remote {
+		cmd, ok := local[r.Name]
+		if !ok || r.Type != discord.ChatInputCommand {
+			if opts.KeepUnknown {
+				plan.Keep = append(plan.Keep, r.Name)
+			} else {
+				plan.Delete = append(plan.Delete, r.Name)
+				plan.delete = append(plan.delete, r.ID)
+			}
+			continue
+		}
+		seen[r.Name] = struct{}{}
+
+		normalised, err := normaliseLocal(cmd.marshal(), guildId, raw)
+		if err != nil {
+			return SyncPlan{}, err
+		}
+		if reflect.DeepEqual(normalised, r.normalised) {
+			plan.Unchanged = append(plan.Unchanged, r.Name)
+			plan.same[r.ID] = cmd
+		} else {
+			plan.Update = append(plan.Update, r.Name)
+			plan.update[r.ID] = cmd
+		}
+	}
+	for name, cmd := range local {
+		if _, ok := seen[name]; !ok {
+			plan.Create = append(plan.Create, name)
+			plan.create = append(plan.create, cmd)
+		}
+	}
+	return plan, nil
+}
+
+// commonCommandFields are the fields of a command that are compared when synchronising commands. extendedCommandFields
+// are compared only if the API supports raw requests, as they are otherwise not sent or received.
+var (
+	commonCommandFields   = []string{"name", "description", "type", "options", "default_permission"}
+	extendedCommandFields = []string{"default_member_permissions", "dm_permission", "nsfw"}
+)
+
+// defaultCommandFields contains the values discord uses for fields of a command when they are omitted.
+var defaultCommandFields = map[string]any{
+	"type":               float64(discord.ChatInputCommand),
+	"default_permission": true,
+	"dm_permission":      true,
+}
+
+// normaliseLocal returns the normalised form of a command of the handler.
+func normaliseLocal(data commandData, guildId discord.GuildID, raw bool) (any, error) {
+	var b []byte
+	var err error
+	if raw {
+		b, err = json.Marshal(data)
+	} else {
+		b, err = json.Marshal(data.CreateCommandData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normaliseCommand(b, guildId, raw)
+}
+
+// normaliseRemote returns the normalised form of a command registered to discord. The raw JSON of the command is used
+// if it is not nil, as it contains fields that are not supported by discord.Command.
+func normaliseRemote(cmd discord.Command, rawData json.RawMessage, guildId discord.GuildID) (any, error) {
+	// Marshalling the command in the same way as the commands of the handler makes sure the options are in the same
+	// format.
+	b, err := json.Marshal(api.CreateCommandData{
+		Name:                cmd.Name,
+		Description:         cmd.Description,
+		Options:             cmd.Options,
+		NoDefaultPermission: cmd.NoDefaultPermission,
+		Type:                cmd.Type,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rawData == nil {
+		return normaliseCommand(b, guildId, false)
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	extended := map[string]json.RawMessage{}
+	if err = json.Unmarshal(rawData, &extended); err != nil {
+		return nil, err
+	}
+	for _, field := range extendedCommandFields {
+		if v, ok := extended[field]; ok {
+			fields[field] = v
+		}
+	}
+	if b, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	return normaliseCommand(b, guildId, true)
+}
+
+// normaliseCommand returns the normalised form of the JSON of a command, which only contains the fields that are
+// compared when synchronising commands. Fields with default or empty values are removed, so that it does not matter
+// whether they were omitted or not.
+func normaliseCommand(b []byte, guildId discord.GuildID, raw bool) (any, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	keys := commonCommandFields
+	if raw {
+		keys = append(keys[:len(keys):len(keys)], extendedCommandFields...)
+	}
+
+	normalised := map[string]any{}
+	for _, key := range keys {
+		v, ok := fields[key]
+		// Commands registered in a guild can never be used in direct messages, so the field does not matter for them.
+		if !ok || key == "dm_permission" && guildId.IsValid() {
+			continue
+		}
+		if def, ok := defaultCommandFields[key]; ok {
+			// Fields with a default value are kept whenever they differ from it, even if the value itself is empty,
+			// such as a dm_permission of false.
+			if v != nil && !reflect.DeepEqual(v, def) {
+				normalised[key] = v
+			}
+			continue
+		}
+		if v = normaliseValue(v); v != nil {
+			normalised[key] = v
+		}
+	}
+	return normalised, nil
+}
+
+// normaliseValue removes all empty values from a decoded JSON value. It returns nil if the value itself is empty.
+func normaliseValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := map[string]any{}
+		for key, val := range v {
+			if val = normaliseValue(val); val != nil {
+				m[key] = val
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+		s := make([]any, len(v))
+		for i, val := range v {
+			s[i] = normaliseValue(val)
+		}
+		return s
+	case bool:
+		if !v {
+			return nil
+		}
+	case string:
+		if v == "" {
+			return nil
+		}
+	}
+	return v
+}
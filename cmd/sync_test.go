@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// testExecutor is an Executor with a single option, used to create commands in tests.
+type testExecutor struct {
+	Text string `description:"Some text."`
+}
+
+// Run ...
+func (testExecutor) Run(*Interaction) {}
+
+// remoteOf returns the command provided as if it was registered to discord with the ID provided.
+func remoteOf(t *testing.T, id discord.CommandID, c Command, guildId discord.GuildID, raw bool) remoteCommand {
+	t.Helper()
+	normalised, err := normaliseLocal(c.marshal(), guildId, raw)
+	if err != nil {
+		t.Fatalf("normalise %s: %v", c.name, err)
+	}
+	return remoteCommand{
+		Command:    discord.Command{ID: id, Type: discord.ChatInputCommand, Name: c.name, Description: c.description},
+		normalised: normalised,
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	ping := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+	echo := New("echo", "Echoes text.").WithExecutor(testExecutor{})
+	changed := New("ping", "Pings the bot, but differently.").WithExecutor(testExecutor{})
+
+	tests := []struct {
+		name   string
+		local  []Command
+		remote []remoteCommand
+		opts   SyncOptions
+		want   SyncPlan
+	}{{
+		name:  "create",
+		local: []Command{ping, echo},
+		want:  SyncPlan{Create: []string{"echo", "ping"}},
+	}, {
+		name:   "unchanged",
+		local:  []Command{ping},
+		remote: []remoteCommand{remoteOf(t, 1, ping, discord.NullGuildID, true)},
+		want:   SyncPlan{Unchanged: []string{"ping"}},
+	}, {
+		name:   "update",
+		local:  []Command{ping},
+		remote: []remoteCommand{remoteOf(t, 1, changed, discord.NullGuildID, true)},
+		want:   SyncPlan{Update: []string{"ping"}},
+	}, {
+		name:   "delete",
+		local:  []Command{ping},
+		remote: []remoteCommand{remoteOf(t, 1, ping, discord.NullGuildID, true), remoteOf(t, 2, echo, discord.NullGuildID, true)},
+		want:   SyncPlan{Delete: []string{"echo"}, Unchanged: []string{"ping"}},
+	}, {
+		name:   "keep unknown",
+		local:  []Command{ping},
+		remote: []remoteCommand{remoteOf(t, 1, ping, discord.NullGuildID, true), remoteOf(t, 2, echo, discord.NullGuildID, true)},
+		opts:   SyncOptions{KeepUnknown: true},
+		want:   SyncPlan{Keep: []string{"echo"}, Unchanged: []string{"ping"}},
+	}, {
+		name:  "other command type",
+		local: []Command{ping},
+		remote: []remoteCommand{{
+			Command: discord.Command{ID: 1, Type: discord.UserCommand, Name: "ping"},
+		}},
+		want: SyncPlan{Create: []string{"ping"}, Delete: []string{"ping"}},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			local := map[string]Command{}
+			for _, c := range test.local {
+				local[c.name] = c
+			}
+			plan, err := planSync(discord.NullGuildID, local, test.remote, test.opts, true)
+			if err != nil {
+				t.Fatalf("planSync: %v", err)
+			}
+			for _, names := range [][]string{plan.Create, plan.Update, plan.Delete, plan.Keep, plan.Unchanged} {
+				sort.Strings(names)
+			}
+			got := SyncPlan{Create: plan.Create, Update: plan.Update, Delete: plan.Delete, Keep: plan.Keep, Unchanged: plan.Unchanged}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("planSync() = %+v, want %+v", got, test.want)
+			}
+			if n := len(plan.create); n != len(test.want.Create) {
+				t.Errorf("planSync() creates %d commands, want %d", n, len(test.want.Create))
+			}
+			if n := len(plan.delete); n != len(test.want.Delete) {
+				t.Errorf("planSync() deletes %d commands, want %d", n, len(test.want.Delete))
+			}
+		})
+	}
+}
+
+func TestNormaliseCommand(t *testing.T) {
+	const base = `{"name":"ping","description":"Pings the bot."`
+	guild := discord.GuildID(1234)
+
+	tests := []struct {
+		name    string
+		a, b    string
+		guildId discord.GuildID
+		raw     bool
+		equal   bool
+	}{{
+		name:  "default fields",
+		a:     base + `}`,
+		b:     base + `,"type":1,"default_permission":true,"dm_permission":true,"nsfw":false,"options":[]}`,
+		raw:   true,
+		equal: true,
+	}, {
+		name: "default permission disabled",
+		a:    base + `}`,
+		b:    base + `,"default_permission":false}`,
+		raw:  true,
+	}, {
+		name:  "unknown fields",
+		a:     base + `}`,
+		b:     base + `,"id":"1","application_id":"2","version":"3"}`,
+		raw:   true,
+		equal: true,
+	}, {
+		name: "global dm permission",
+		a:    base + `,"dm_permission":true}`,
+		b:    base + `,"dm_permission":false}`,
+		raw:  true,
+	}, {
+		name:    "guild dm permission",
+		a:       base + `,"dm_permission":true}`,
+		b:       base + `,"dm_permission":false}`,
+		guildId: guild,
+		raw:     true,
+		equal:   true,
+	}, {
+		name:  "null default member permissions",
+		a:     base + `}`,
+		b:     base + `,"default_member_permissions":null}`,
+		raw:   true,
+		equal: true,
+	}, {
+		name: "null and set default member permissions",
+		a:    base + `,"default_member_permissions":null}`,
+		b:    base + `,"default_member_permissions":"8"}`,
+		raw:  true,
+	}, {
+		name:  "equal default member permissions",
+		a:     base + `,"default_member_permissions":"8"}`,
+		b:     base + `,"default_member_permissions":"8"}`,
+		raw:   true,
+		equal: true,
+	}, {
+		name:  "extended fields without raw requests",
+		a:     base + `,"default_member_permissions":null,"nsfw":false}`,
+		b:     base + `,"default_member_permissions":"8","nsfw":true}`,
+		equal: true,
+	}, {
+		name: "options",
+		a:    base + `,"options":[{"type":3,"name":"text","description":"Some text.","required":true}]}`,
+		b:    base + `,"options":[{"type":3,"name":"text","description":"Some text."}]}`,
+		raw:  true,
+	}, {
+		name:  "empty option fields",
+		a:     base + `,"options":[{"type":3,"name":"text","description":"Some text.","choices":[],"autocomplete":false}]}`,
+		b:     base + `,"options":[{"type":3,"name":"text","description":"Some text."}]}`,
+		raw:   true,
+		equal: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := normaliseCommand([]byte(test.a), test.guildId, test.raw)
+			if err != nil {
+				t.Fatalf("normaliseCommand(%s): %v", test.a, err)
+			}
+			b, err := normaliseCommand([]byte(test.b), test.guildId, test.raw)
+			if err != nil {
+				t.Fatalf("normaliseCommand(%s): %v", test.b, err)
+			}
+			if equal := reflect.DeepEqual(a, b); equal != test.equal {
+				t.Errorf("normaliseCommand(%s) = %v, normaliseCommand(%s) = %v, equal %v, want %v", test.a, a, test.b, b, equal, test.equal)
+			}
+		})
+	}
+}
+
+func TestNormaliseLocal(t *testing.T) {
+	c := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+	for _, raw := range []bool{false, true} {
+		for _, guildId := range []discord.GuildID{discord.NullGuildID, 1234} {
+			local, err := normaliseLocal(c.marshal(), guildId, raw)
+			if err != nil {
+				t.Fatalf("normaliseLocal: %v", err)
+			}
+			var rawData []byte
+			if raw {
+				// Discord returns the fields even if they have their default value.
+				rawData = []byte(`{"default_member_permissions":null,"dm_permission":true,"nsfw":false}`)
+			}
+			remote, err := normaliseRemote(discord.Command{
+				Type:        discord.ChatInputCommand,
+				Name:        "ping",
+				Description: "Pings the bot.",
+				Options:     c.marshal().Options,
+			}, rawData, guildId)
+			if err != nil {
+				t.Fatalf("normaliseRemote: %v", err)
+			}
+			if !reflect.DeepEqual(local, remote) {
+				t.Errorf("raw %v, guild %v: normaliseLocal() = %v, normaliseRemote() = %v", raw, guildId, local, remote)
+			}
+		}
+	}
+}
+
+func TestNormaliseValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want any
+	}{
+		{name: "nil", v: nil, want: nil},
+		{name: "false", v: false, want: nil},
+		{name: "true", v: true, want: true},
+		{name: "empty string", v: "", want: nil},
+		{name: "string", v: "a", want: "a"},
+		{name: "zero", v: float64(0), want: float64(0)},
+		{name: "empty slice", v: []any{}, want: nil},
+		{name: "slice", v: []any{"a", "", false}, want: []any{"a", nil, nil}},
+		{name: "empty map", v: map[string]any{"a": "", "b": false, "c": []any{}}, want: nil},
+		{
+			name: "nested map",
+			v:    map[string]any{"a": "x", "b": map[string]any{"c": false}, "d": []any{map[string]any{"e": 1.0, "f": ""}}},
+			want: map[string]any{"a": "x", "d": []any{map[string]any{"e": 1.0}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normaliseValue(test.v); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("normaliseValue(%v) = %v, want %v", test.v, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashCommandData(t *testing.T) {
+	ping := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+	echo := New("echo", "Echoes text.").WithExecutor(testExecutor{})
+
+	tests := []struct {
+		name  string
+		a, b  []Command
+		equal bool
+	}{
+		{name: "same commands", a: []Command{ping, echo}, b: []Command{ping, echo}, equal: true},
+		{name: "different order", a: []Command{ping, echo}, b: []Command{echo, ping}, equal: true},
+		{name: "missing command", a: []Command{ping, echo}, b: []Command{ping}},
+		{name: "different description", a: []Command{ping}, b: []Command{New("ping", "Pings.").WithExecutor(testExecutor{})}},
+		{name: "default member permissions", a: []Command{ping}, b: []Command{ping.WithDefaultMemberPermissions(discord.PermissionAdministrator)}},
+		{name: "dm permission", a: []Command{ping}, b: []Command{ping.WithoutDMPermission()}},
+		{name: "nsfw", a: []Command{ping}, b: []Command{ping.WithNSFW()}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := HashCommands(test.a...)
+			if err != nil {
+				t.Fatalf("HashCommands: %v", err)
+			}
+			b, err := HashCommands(test.b...)
+			if err != nil {
+				t.Fatalf("HashCommands: %v", err)
+			}
+			if equal := a == b; equal != test.equal {
+				t.Errorf("HashCommands() = %s and %s, equal %v, want %v", a, b, equal, test.equal)
+			}
+		})
+	}
+}
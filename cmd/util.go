@@ -2,16 +2,59 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 )
 
 // commandRegex is a regex that all command names and parameters must match to.
 var commandRegex = regexp.MustCompile("^[\\w-]{1,32}$")
 
-// mustMatch checks if the provided string matches commandRegex. If this is not the case, the function will panic. This
-// is used to check command names and parameters, which must be lowercase.
-func mustMatch(param string) {
-	if !commandRegex.MatchString(param) {
-		panic(fmt.Sprintf("string %s does not match expected pattern", param))
+// must returns the command provided, or panics if the error provided is not nil. It is used by the builder methods of
+// Command that panic on invalid input.
+func must(c Command, err error) Command {
+	if err != nil {
+		panic(err)
 	}
+	return c
+}
+
+// cloneMap returns a copy of the map provided. Builder methods of Command clone maps before changing them, as the maps
+// are otherwise shared with all other copies of the command.
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	clone := make(map[K]V, len(m)+1)
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// callerSource returns the file and line of the function skip frames above the caller of callerSource. It is used to
+// record where commands and subcommands were defined.
+func callerSource(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "an unknown location"
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+// writeFileAtomic writes the data provided to the file at the path provided. The file is replaced atomically by first
+// writing to a temporary file in the same directory, so that it never ends up partially written.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
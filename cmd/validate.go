@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+const (
+	// maxOptions is the maximum amount of options, subcommands and subcommand groups a command, subcommand group or
+	// subcommand can have.
+	maxOptions = 25
+	// maxCommandLength is the maximum combined length of the names and descriptions of a command and all its
+	// subcommand groups, subcommands and options.
+	maxCommandLength = 4000
+	// maxChatCommands is the maximum amount of chat commands that can be registered globally or in a single guild.
+	// Discord also limits the amount of user and message commands to 5, but only chat commands can be created.
+	maxChatCommands = 100
+)
+
+// ValidationError is a single problem found when validating a command.
+type ValidationError struct {
+	// Path is the path to the part of the command that the problem was found in, such as "/command group subcommand"
+	// or "/command option".
+	Path string
+	// Message describes the problem.
+	Message string
+}
+
+// Error ...
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors contains all problems found when validating one or more commands. It is returned by
+// Command.Validate and by all methods of the Handler registering commands, before any API call is made.
+type ValidationErrors []ValidationError
+
+// Error ...
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid command: " + e[0].Error()
+	}
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("invalid commands (%d problems): %s", len(e), strings.Join(messages, "; "))
+}
+
+// err returns the ValidationErrors as error, or nil if there are none.
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the command against the limits discord puts on commands, and returns ValidationErrors containing every
+// problem found. If the command is valid, nil is returned.
+func (c Command) Validate() error {
+	return c.validate().err()
+}
+
+// validate returns all problems found in the command.
+func (c Command) validate() (errs ValidationErrors) {
+	path := "/" + c.name
+	errs = append(errs, validateName(path, c.registeredName())...)
+	errs = append(errs, validateDescription(path, c.description)...)
+	length := utf8.RuneCountInString(c.registeredName()) + utf8.RuneCountInString(c.description)
+
+	if c.executor != nil {
+		optErrs, optLength := validateExecutor(path, c.executor)
+		errs, length = append(errs, optErrs...), length+optLength
+	} else if len(c.subcommands) == 0 {
+		errs = append(errs, ValidationError{Path: path, Message: "command has neither an executor nor subcommands"})
+	} else {
+		top, groups := c.subcommandLayout()
+		if len(top) > maxOptions {
+			errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("command has %d subcommands and subcommand groups, the maximum is %d", len(top), maxOptions)})
+		}
+		// The subcommands and groups are walked in the order they were added, so that problems are always reported in
+		// the same order.
+		for _, name := range c.order {
+			sub, ok := c.subcommands[name]
+			if !ok {
+				groupPath := path + " " + name
+				description := c.subGroups[name]
+				errs = append(errs, validateName(groupPath, name)...)
+				errs = append(errs, validateDescription(groupPath, description)...)
+				if n := len(groups[name]); n > maxOptions {
+					errs = append(errs, ValidationError{Path: groupPath, Message: fmt.Sprintf("subcommand group has %d subcommands, the maximum is %d", n, maxOptions)})
+				}
+				if len(groups[name]) > 0 {
+					length += utf8.RuneCountInString(name) + utf8.RuneCountInString(description)
+				}
+				continue
+			}
+			subPath := path + " " + name
+			errs = append(errs, validateName(subPath, sub.name)...)
+			errs = append(errs, validateDescription(subPath, sub.description)...)
+			optErrs, optLength := validateExecutor(subPath, sub.executor)
+			errs = append(errs, optErrs...)
+			length += utf8.RuneCountInString(sub.name) + utf8.RuneCountInString(sub.description) + optLength
+		}
+	}
+	if length > maxCommandLength {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("command has %d characters in total, the maximum is %d", length, maxCommandLength)})
+	}
+	return errs
+}
+
+// validateCommands validates the commands provided, which are all registered in the same scope.
+func validateCommands(guildId discord.GuildID, commands map[string]Command) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ValidationErrors
+	for _, name := range names {
+		errs = append(errs, commands[name].validate()...)
+	}
+	if len(commands) > maxChatCommands {
+		errs = append(errs, ValidationError{Path: scopeName(guildId), Message: fmt.Sprintf("%d commands registered, the maximum is %d", len(commands), maxChatCommands)})
+	}
+	return errs.err()
+}
+
+// validateName returns the problems with the name of a command, subcommand group, subcommand or option.
+func validateName(path, name string) (errs ValidationErrors) {
+	if n := utf8.RuneCountInString(name); n < nameMinLength || n > nameMaxLength {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("name must be between %d and %d characters in length", nameMinLength, nameMaxLength)})
+	} else if !commandRegex.MatchString(name) {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("name %q may only contain letters, numbers, underscores and dashes", name)})
+	}
+	if strings.ToLower(name) != name {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("name %q must be lowercase", name)})
+	}
+	return errs
+}
+
+// validateDescription returns the problems with the description of a command, subcommand group, subcommand or option.
+func validateDescription(path, description string) (errs ValidationErrors) {
+	if n := utf8.RuneCountInString(description); n < descMinLength || n > descMaxLength {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("description must be between %d and %d characters in length", descMinLength, descMaxLength)})
+	}
+	return errs
+}
+
+// validateExecutor returns the problems with the options of an Executor or ErrorExecutor, together with the combined
+// length of the names and descriptions of the options.
+func validateExecutor(path string, e any) (errs ValidationErrors, length int) {
+	t := reflect.TypeOf(e)
+	if t == nil || t.Kind() != reflect.Struct {
+		return ValidationErrors{{Path: path, Message: "executor must be a struct"}}, 0
+	}
+
+	errs = duplicateOptions(path, e)
+	var count int
+	var lastOptional bool
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		count++
+
+		name := strings.ToLower(field.Name)
+		desc := field.Tag.Get("description")
+		optPath := path + " " + name
+		errs = append(errs, validateName(optPath, name)...)
+		errs = append(errs, validateDescription(optPath, desc)...)
+		length += utf8.RuneCountInString(name) + utf8.RuneCountInString(desc)
+
+		instance := reflect.New(field.Type).Elem().Interface()
+		opt, isOptional := instance.(optional)
+		if isOptional {
+			instance = opt.get()
+			lastOptional = true
+		} else if lastOptional {
+			errs = append(errs, ValidationError{Path: optPath, Message: "required options must come before all optional options"})
+		}
+		if !supportedParameter(instance) {
+			errs = append(errs, ValidationError{Path: optPath, Message: fmt.Sprintf("unsupported parameter type %s", field.Type)})
+		}
+	}
+	if count > maxOptions {
+		errs = append(errs, ValidationError{Path: path, Message: fmt.Sprintf("executor has %d options, the maximum is %d", count, maxOptions)})
+	}
+	return errs, length
+}
+
+// supportedParameter returns whether the value provided has a type that can be used as parameter of an executor.
+func supportedParameter(v any) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, string, bool,
+		User, Role, Mentionable, Channel:
+		return true
+	}
+	return false
+}
+
+// duplicateOptions returns the problems with options of an Executor or ErrorExecutor of which the names are used by
+// multiple fields, which happens when field names only differ in case. Both fields are named in the problem.
+func duplicateOptions(path string, e any) (errs ValidationErrors) {
+	t := reflect.TypeOf(e)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	names := map[string]string{} // option name: field name
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := strings.ToLower(field.Name)
+		if other, ok := names[name]; ok {
+			errs = append(errs, ValidationError{Path: path + " " + name, Message: fmt.Sprintf("option name is used by both field %s.%s and field %s.%s", t, other, t, field.Name)})
+			continue
+		}
+		names[name] = field.Name
+	}
+	return errs
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// optionalFirstExecutor has a required option after an optional one.
+type optionalFirstExecutor struct {
+	First  Optional[string] `description:"The first option."`
+	Second string           `description:"The second option."`
+}
+
+// Run ...
+func (optionalFirstExecutor) Run(*Interaction) {}
+
+// invalidOptionsExecutor has options with an invalid name, a missing description and an unsupported type.
+type invalidOptionsExecutor struct {
+	Név   string  `description:"An option with an invalid name."`
+	Empty string  ``
+	Slice []int   `description:"An option of an unsupported type."`
+	Float float64 `description:"A valid option."`
+}
+
+// Run ...
+func (invalidOptionsExecutor) Run(*Interaction) {}
+
+// manyOptionsExecutor returns an executor with the amount of options provided.
+func manyOptionsExecutor(n int) any {
+	fields := make([]reflect.StructField, n)
+	for i := range fields {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Option%d", i),
+			Type: reflect.TypeOf(""),
+			Tag:  `description:"An option."`,
+		}
+	}
+	return reflect.New(reflect.StructOf(fields)).Elem().Interface()
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		command func() Command
+		want    ValidationErrors
+	}{{
+		name: "valid",
+		command: func() Command {
+			return New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+		},
+	}, {
+		name: "valid subcommands",
+		command: func() Command {
+			return New("admin", "Admin commands.").
+				WithSubcommandGroup("user", "User commands.").
+				WithSubcommand("user ban", "Bans a user.", testExecutor{}).
+				WithSubcommand("reload", "Reloads the bot.", testExecutor{})
+		},
+	}, {
+		name: "no executor",
+		command: func() Command {
+			return New("ping", "Pings the bot.")
+		},
+		want: ValidationErrors{{Path: "/ping", Message: "command has neither an executor nor subcommands"}},
+	}, {
+		name: "optional before required",
+		command: func() Command {
+			return New("ping", "Pings the bot.").WithExecutor(optionalFirstExecutor{})
+		},
+		want: ValidationErrors{{Path: "/ping second", Message: "required options must come before all optional options"}},
+	}, {
+		name: "invalid options",
+		command: func() Command {
+			return New("ping", "Pings the bot.").WithExecutor(invalidOptionsExecutor{})
+		},
+		want: ValidationErrors{
+			{Path: "/ping név", Message: `name "név" may only contain letters, numbers, underscores and dashes`},
+			{Path: "/ping empty", Message: "description must be between 1 and 100 characters in length"},
+			{Path: "/ping slice", Message: "unsupported parameter type []int"},
+		},
+	}, {
+		name: "invalid subcommand options",
+		command: func() Command {
+			return New("admin", "Admin commands.").WithSubcommand("ban", "Bans a user.", optionalFirstExecutor{})
+		},
+		want: ValidationErrors{{Path: "/admin ban second", Message: "required options must come before all optional options"}},
+	}, {
+		name: "too many options",
+		command: func() Command {
+			c := New("ping", "Pings the bot.")
+			c.executor = manyOptionsExecutor(maxOptions + 1)
+			return c
+		},
+		want: ValidationErrors{{Path: "/ping", Message: "executor has 26 options, the maximum is 25"}},
+	}, {
+		name: "too many subcommands",
+		command: func() Command {
+			c := New("admin", "Admin commands.")
+			for i := 0; i <= maxOptions; i++ {
+				c = c.WithSubcommand(fmt.Sprintf("sub%d", i), "A subcommand.", testExecutor{})
+			}
+			return c
+		},
+		want: ValidationErrors{{Path: "/admin", Message: "command has 26 subcommands and subcommand groups, the maximum is 25"}},
+	}, {
+		name: "too many characters",
+		command: func() Command {
+			c := New("admin", "Admin commands.")
+			description := strings.Repeat("a", descMaxLength)
+			for _, group := range []string{"g0", "g1"} {
+				c = c.WithSubcommandGroup(group, "A group.")
+				for i := 0; i < 20; i++ {
+					c = c.WithSubcommand(fmt.Sprintf("%s s%d", group, i), description, testExecutor{})
+				}
+			}
+			return c
+		},
+		want: ValidationErrors{{Path: "/admin", Message: "command has 4700 characters in total, the maximum is 4000"}},
+	}, {
+		name: "dev prefix",
+		command: func() Command {
+			c := New("ping", "Pings the bot.").WithExecutor(testExecutor{})
+			c.prefix = "Dev-"
+			return c
+		},
+		want: ValidationErrors{{Path: "/ping", Message: `name "Dev-ping" must be lowercase`}},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.command().validate(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("validate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	commands := map[string]Command{}
+	for i := 0; i <= maxChatCommands; i++ {
+		c := New(fmt.Sprintf("command%d", i), "A command.").WithExecutor(testExecutor{})
+		commands[c.name] = c
+	}
+	commands["command0"] = New("command0", "A command.")
+
+	err := validateCommands(discord.NullGuildID, commands)
+	want := ValidationErrors{
+		{Path: "/command0", Message: "command has neither an executor nor subcommands"},
+		{Path: scopeName(discord.NullGuildID), Message: "101 commands registered, the maximum is 100"},
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("validateCommands() = %v, want %v", err, want)
+	}
+	if err := validateCommands(discord.NullGuildID, map[string]Command{"command1": commands["command1"]}); err != nil {
+		t.Errorf("validateCommands() = %v, want nil", err)
+	}
+}
+
+func TestTryNew(t *testing.T) {
+	tests := []struct {
+		name, description string
+		want              ValidationErrors
+	}{
+		{name: "ping", description: "Pings the bot."},
+		{name: "", description: "Pings the bot.", want: ValidationErrors{{Path: "/", Message: "name must be between 1 and 32 characters in length"}}},
+		{name: "Ping", description: "Pings the bot.", want: ValidationErrors{{Path: "/Ping", Message: `name "Ping" must be lowercase`}}},
+		{name: "ping", description: strings.Repeat("a", descMaxLength+1), want: ValidationErrors{{Path: "/ping", Message: "description must be between 1 and 100 characters in length"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := TryNew(test.name, test.description)
+			if test.want == nil && err != nil || test.want != nil && !reflect.DeepEqual(err, test.want) {
+				t.Errorf("TryNew(%q, %q) = %v, want %v", test.name, test.description, err, test.want)
+			}
+		})
+	}
+}
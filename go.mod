@@ -3,11 +3,14 @@ module github.com/andreashgk/go-interactions
 go 1.18
 
 require (
-	github.com/diamondburned/arikawa/v3 v3.0.0-rc.4.0.20220119053658-40ff267a7485 // indirect
+	github.com/diamondburned/arikawa/v3 v3.0.0-rc.4.0.20220119053658-40ff267a7485
+	go.uber.org/atomic v1.9.0
+)
+
+require (
 	github.com/gorilla/schema v1.2.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	go.uber.org/atomic v1.9.0 // indirect
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/sys v0.0.0-20211001092434-39dca1131b70 // indirect
 	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac // indirect